@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	testEventType       = "__test__"
+	userStatusEventType = "user_status_changed"
+	disconnectEventType = "disconnected"
+	testAckTimeout      = 5 * time.Second
+
+	defaultReplayLimit = 100
+	maxReplayLimit     = 1000
+)
+
+// adminStatsHandler reports in-process counters only, so it stays cheap
+// enough to poll: no database round trip is made.
+func (s *Server) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	dbStats := s.db.Stats()
+
+	subscribers := make(map[string]int)
+	s.clients.View(func(clients []clientEntry) {
+		for _, c := range clients {
+			subscribers[c.meta.Channel]++
+		}
+	})
+	channels := make(map[string]any)
+	s.channelStats.Range(func(k, v any) bool {
+		channels[k.(string)] = map[string]int64{
+			"lifetime_events":     v.(*channelStats).broadcast.Load(),
+			"current_subscribers": int64(subscribers[k.(string)]),
+		}
+		return true
+	})
+	for name, n := range subscribers {
+		if _, ok := channels[name]; !ok {
+			channels[name] = map[string]int64{"lifetime_events": 0, "current_subscribers": int64(n)}
+		}
+	}
+	for _, info := range s.channels.List() {
+		if _, ok := channels[info.Name]; !ok {
+			channels[info.Name] = map[string]int64{"lifetime_events": 0, "current_subscribers": 0}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"connected_clients": s.TotalClients(),
+		"total_broadcasts":  s.totalBroadcasts.Load(),
+		"total_dropped":     s.totalDropped.Load(),
+		"uptime_seconds":    int64(s.clock.Now().Sub(s.startedAt).Seconds()),
+		"db": map[string]any{
+			"open":       dbStats.OpenConnections,
+			"in_use":     dbStats.InUse,
+			"idle":       dbStats.Idle,
+			"wait_count": dbStats.WaitCount,
+		},
+		"goroutines": runtime.NumGoroutine(),
+		"channels":   channels,
+	})
+}
+
+type purgeEventsRequest struct {
+	OlderThan string `json:"older_than"`
+	Channel   string `json:"channel"`
+	DryRun    bool   `json:"dry_run"`
+}
+
+func (s *Server) adminPurgeEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var req purgeEventsRequest
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, s.config.MaxPayloadBytes), &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	age, err := parseAge(req.OlderThan)
+	if err != nil || age <= 0 {
+		http.Error(w, "older_than must be a positive duration such as 24h or 7d", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.purgeEvents(r.Context(), s.clock.Now().Add(-age), req.Channel, req.DryRun)
+	if err != nil {
+		s.logger.Error("Failed to purge events", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("Purged events",
+		"older_than", req.OlderThan,
+		"channel", req.Channel,
+		"dry_run", req.DryRun,
+		"deleted", res.Deleted)
+	writeJSON(w, http.StatusOK, res)
+}
+
+// adminTestBroadcastHandler sends a synthetic event to the channel in
+// ?channel= and waits for a monitoring client subscribed to it to echo
+// the nonce back through /admin/test-ack. Routing rules are bypassed so
+// the event reaches that channel only.
+func (s *Server) adminTestBroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		channel = defaultChannel
+	}
+	if verr := s.validateChannelName(channel); verr != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "invalid_channel_name",
+			"reason": verr.Reason,
+			"detail": verr.Detail,
+		})
+		return
+	}
+
+	nonce := uuid.NewString()
+	acked := make(chan time.Time, 1)
+	s.testAcks.Store(nonce, acked)
+	defer s.testAcks.Delete(nonce)
+
+	sent := s.clock.Now()
+	msg, _ := jsonMarshal(map[string]any{
+		"event":       testEventType,
+		"nonce":       nonce,
+		"server_time": sent.UnixMilli(),
+	})
+	s.deliver(r.Context(), map[string]bool{channel: true}, &Frame{Event: testEventType, Data: msg})
+
+	timer := time.NewTimer(testAckTimeout)
+	defer timer.Stop()
+
+	select {
+	case at := <-acked:
+		writeJSON(w, http.StatusOK, map[string]any{
+			"delivered":  true,
+			"latency_ms": at.Sub(sent).Milliseconds(),
+		})
+	case <-timer.C:
+		writeJSON(w, http.StatusOK, map[string]any{"delivered": false})
+	case <-r.Context().Done():
+	}
+}
+
+// adminTestAckHandler completes the test broadcast waiting on ?nonce=.
+func (s *Server) adminTestAckHandler(w http.ResponseWriter, r *http.Request) {
+	v, ok := s.testAcks.Load(r.URL.Query().Get("nonce"))
+	if !ok {
+		http.Error(w, "Unknown or expired nonce", http.StatusNotFound)
+		return
+	}
+
+	select {
+	case v.(chan time.Time) <- s.clock.Now():
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type replayRequest struct {
+	Since  time.Time `json:"since"`
+	Before time.Time `json:"before"`
+	Limit  int       `json:"limit"`
+}
+
+// adminReplayHandler re-broadcasts stored events of the channel in the
+// path, oldest first, marking each with "replayed": true. Progress is
+// streamed as one JSON object per line as events go out.
+func (s *Server) adminReplayHandler(w http.ResponseWriter, r *http.Request) {
+	channel := r.PathValue("name")
+
+	var req replayRequest
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, s.config.MaxPayloadBytes), &req); err != nil {
+		http.Error(w, "Invalid JSON body: since and before must be RFC 3339 times", http.StatusBadRequest)
+		return
+	}
+	if req.Before.IsZero() {
+		req.Before = s.clock.Now()
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultReplayLimit
+	}
+	req.Limit = min(req.Limit, maxReplayLimit)
+
+	events, err := s.eventsBetween(r.Context(), channel, req.Since, req.Before, req.Limit)
+	if err != nil {
+		s.logger.Error("Failed to load events for replay", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	replayed := 0
+	for _, e := range events {
+		if r.Context().Err() != nil {
+			break
+		}
+		msg, err := markReplayed(e.Message)
+		if err != nil {
+			s.logger.Warn("Skipping unreadable event in replay", "event_id", e.EventID, "error", err)
+			continue
+		}
+		s.broadcastToChannel(r.Context(), channel, eventFrame(e.EventID, e.EventType, msg))
+		replayed++
+
+		progress, _ := jsonMarshal(map[string]int{"replayed": replayed, "total": len(events)})
+		w.Write(append(progress, '\n'))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if len(events) == 0 {
+		w.Write([]byte(`{"replayed":0,"total":0}` + "\n"))
+	}
+
+	s.logger.Info("Replayed events", "channel", channel, "replayed", replayed, "total", len(events))
+}
+
+// markReplayed adds "replayed": true to a stored event envelope.
+func markReplayed(msg []byte) ([]byte, error) {
+	var envelope map[string]json.RawMessage
+	if err := jsonUnmarshal(msg, &envelope); err != nil {
+		return nil, err
+	}
+	envelope["replayed"] = json.RawMessage("true")
+	return jsonMarshal(envelope)
+}
+
+type setVerificationRequest struct {
+	Status *bool `json:"status"`
+}
+
+// adminSetVerificationHandler sets verification_status of the user in the
+// path: POST takes {"status": <bool>}, DELETE sets it to false. The
+// user's cached status is dropped and every connected client is told
+// about the change.
+func (s *Server) adminSetVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || id == 0 {
+		http.Error(w, "user id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	userID := id
+
+	status := false
+	if r.Method == http.MethodPost {
+		var req setVerificationRequest
+		if err := decodeJSON(http.MaxBytesReader(w, r.Body, s.config.MaxPayloadBytes), &req); err != nil || req.Status == nil {
+			http.Error(w, `Body must be {"status": true} or {"status": false}`, http.StatusBadRequest)
+			return
+		}
+		status = *req.Status
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dbQueryTimeout)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET verification_status = $1 WHERE id = $2", status, userID)
+	if err != nil {
+		s.logger.Error("Failed to set verification status", "user_id", userID, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	s.authCache.invalidate(userID)
+
+	change := map[string]any{"event": userStatusEventType, "user_id": userID, "status": status}
+	msg, _ := jsonMarshal(change)
+	delivered, _, _ := s.broadcast(r.Context(), eventFrame("", userStatusEventType, msg))
+
+	s.logger.Info("Verification status set", "user_id", userID, "status", status, "clients", delivered)
+	writeJSON(w, http.StatusOK, change)
+}
+
+// adminDisconnectHandler ends every stream of a user on this instance
+// after sending them a disconnected event. Unlike logout it leaves the
+// user's sessions valid, so clients may reconnect.
+func (s *Server) adminDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(r.PathValue("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "user id must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	msg, _ := jsonMarshal(map[string]any{"event": disconnectEventType, "reason": "admin"})
+	n := s.disconnectUser(r.Context(), userID, eventFrame("", disconnectEventType, msg))
+
+	s.logger.Info("Disconnected user", "user_id", userID, "clients", n)
+	writeJSON(w, http.StatusOK, map[string]any{"user_id": userID, "disconnected": n})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetVerificationNotifiesAllClients(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		found      bool
+		wantStatus int
+		wantData   string
+	}{
+		{name: "verify", method: http.MethodPost, body: `{"status":true}`, found: true,
+			wantStatus: http.StatusOK, wantData: `{"event":"user_status_changed","status":true,"user_id":2}`},
+		{name: "unverify", method: http.MethodDelete, found: true,
+			wantStatus: http.StatusOK, wantData: `{"event":"user_status_changed","status":false,"user_id":2}`},
+		{name: "unknown user", method: http.MethodPost, body: `{"status":true}`,
+			wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, _ := newTestServer(t, testConfig())
+			fake.setHandler(func(q fakeQuery) fakeResult {
+				if strings.HasPrefix(q.SQL, "UPDATE users SET verification_status") {
+					if tt.found {
+						return fakeResult{RowsAffected: 1}
+					}
+					return fakeResult{}
+				}
+				return unverifiedUsers(q)
+			})
+			// Users 1 to 3 each have a stream on one channel, and user 2 has
+			// a second one on another.
+			chans := addClients(s, 3, "c", 1)
+			second := make(chan *Frame, 1)
+			s.clients.Add(second, &ClientMeta{UserID: 2, Channel: "other", kick: make(chan struct{})})
+
+			r := authRequest(t, tt.method, "/admin/users/2/verify", 0, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+signToken(t, &Claims{UserID: 9, Role: roleAdmin}))
+			w := serve(s, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			for i, ch := range append(chans, second) {
+				select {
+				case f := <-ch:
+					if tt.wantData == "" {
+						t.Fatalf("client %d got %s for a failed update", i, f.Data)
+					}
+					if f.Event != userStatusEventType {
+						t.Errorf("client %d got event %q", i, f.Event)
+					}
+					// Key order differs between JSON encoders; compare values.
+					var got, want map[string]any
+					if err := json.Unmarshal(f.Data, &got); err != nil {
+						t.Fatalf("client %d got %s: %v", i, f.Data, err)
+					}
+					json.Unmarshal([]byte(tt.wantData), &want)
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("client %d got %s, want %s", i, f.Data, tt.wantData)
+					}
+				default:
+					if tt.wantData != "" {
+						t.Errorf("client %d was not told", i)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type Claims struct {
+	UserID   uint64 `json:"user_id"`
+	Role     string `json:"role,omitempty"`
+	TenantID string `json:"tenant_id,omitempty"`
+	jwt.RegisteredClaims
+
+	// Extra holds every claim not covered by the fields above, such as
+	// deployment-specific plan or org_id claims. Read it with GetExtra.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// knownClaims are the JSON names of the fields Claims decodes itself.
+var knownClaims = map[string]bool{
+	"user_id": true, "role": true, "tenant_id": true,
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+}
+
+// claimsFields has Claims' fields without its JSON methods, so they can
+// use the default encoding for the known claims.
+type claimsFields Claims
+
+// UnmarshalJSON decodes the known claims into their fields and collects
+// the rest in Extra.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	if err := jsonUnmarshal(data, (*claimsFields)(c)); err != nil {
+		return err
+	}
+
+	var all map[string]json.RawMessage
+	if err := jsonUnmarshal(data, &all); err != nil {
+		return err
+	}
+	for k := range knownClaims {
+		delete(all, k)
+	}
+	if len(all) > 0 {
+		c.Extra = all
+	} else {
+		c.Extra = nil
+	}
+	return nil
+}
+
+// MarshalJSON writes Extra alongside the known claims, which win if a key
+// appears in both.
+func (c *Claims) MarshalJSON() ([]byte, error) {
+	data, err := jsonMarshal((*claimsFields)(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := jsonUnmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for k, v := range c.Extra {
+		if _, ok := all[k]; !ok {
+			all[k] = v
+		}
+	}
+	return jsonMarshal(all)
+}
+
+// GetExtra unmarshals the custom claim key into target. It returns false
+// when the claim is absent or does not fit target.
+func (c *Claims) GetExtra(key string, target any) bool {
+	raw, ok := c.Extra[key]
+	if !ok {
+		return false
+	}
+	return jsonUnmarshal(raw, target) == nil
+}
+
+// EffectiveUserID returns the user_id claim, or the standard sub claim
+// parsed as an unsigned integer for IdPs that only issue sub. It returns
+// 0 when neither holds a usable ID.
+func (c *Claims) EffectiveUserID() uint64 {
+	id, _ := c.userID()
+	return id
+}
+
+// userID is EffectiveUserID plus the claim the ID came from.
+func (c *Claims) userID() (uint64, string) {
+	if c.UserID != 0 {
+		return c.UserID, "user_id"
+	}
+	if id, err := strconv.ParseUint(c.Subject, 10, 64); err == nil && id != 0 {
+		return id, "sub"
+	}
+	return 0, ""
+}
+
+// ClaimsFromContext returns the claims stored by authMiddleware.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+const roleAdmin = "admin"
+
+// Values of the error parameter in the WWW-Authenticate header of 401
+// responses.
+const (
+	authErrTokenMissing  = "token_missing"
+	authErrInvalidToken  = "invalid_token"
+	authErrTokenExpired  = "token_expired"
+	authErrInvalidClaims = "invalid_claims"
+)
+
+// unauthorized writes a 401 with the Bearer challenge RFC 7235 requires,
+// naming what was wrong with the credentials. description is also the
+// response body.
+func unauthorized(w http.ResponseWriter, code, description string) {
+	w.Header().Set("WWW-Authenticate",
+		fmt.Sprintf(`Bearer realm="peeple-queue",error=%q,error_description=%q`, code, description))
+	http.Error(w, description, http.StatusUnauthorized)
+}
+
+// authenticate validates the Bearer token and revocation list. It writes
+// the error response itself and returns false when the request must stop.
+//
+// The order of the checks is a security requirement, not a detail: the
+// header is parsed, then the signature and expiry are verified, then the
+// user claim is checked, and only then is the database consulted. A
+// forged or expired token must never cost a query, or anyone could turn
+// unauthenticated requests into database load. Checks that need the
+// database go after jwt.ParseWithClaims, here and in authMiddleware.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*Claims, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		unauthorized(w, authErrTokenMissing, "Authorization header missing")
+		return nil, false
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		unauthorized(w, authErrInvalidToken, "Invalid Authorization header format")
+		return nil, false
+	}
+	tokenString := parts[1]
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		return s.config.JwtSecret, nil
+	})
+
+	if err != nil || !token.Valid {
+		s.logger.Warn("Invalid token attempt", "error", err)
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			unauthorized(w, authErrTokenExpired, "Token expired")
+		} else {
+			unauthorized(w, authErrInvalidToken, "Invalid token")
+		}
+		return nil, false
+	}
+
+	userID, source := claims.userID()
+	if userID == 0 {
+		unauthorized(w, authErrInvalidClaims, "Invalid user claims")
+		return nil, false
+	}
+
+	// Tokens with a jti can be tied to a row in sessions, which lets a
+	// single session be revoked before the JWT itself expires.
+	if claims.ID != "" {
+		valid, err := s.sessionValid(r.Context(), claims.ID)
+		if err != nil {
+			s.logger.Error("Database query error", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return nil, false
+		}
+		if !valid {
+			unauthorized(w, authErrInvalidToken, "Session expired or revoked")
+			return nil, false
+		}
+	}
+
+	s.logger.Debug("Request authenticated",
+		"user_id", userID,
+		"user_id_source", source,
+		"request_id", RequestIDFromContext(r.Context()),
+		"path", r.URL.Path)
+	return claims, true
+}
+
+func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := s.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		// users.verification_status is true once the user has submitted
+		// their verification request; from then on they are locked out.
+		// It is looked up only after authenticate has verified the token.
+		userID := claims.EffectiveUserID()
+		alreadySubmitted, cached := s.authCache.get(userID, s.clock.Now())
+		if !cached {
+			err := s.readDB.QueryRow("SELECT verification_status FROM users WHERE id = $1", userID).Scan(&alreadySubmitted)
+
+			if err != nil {
+				if err == sql.ErrNoRows {
+					unauthorized(w, authErrInvalidClaims, "User not found")
+				} else {
+					s.logger.Error("Database query error", "error", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				}
+				return
+			}
+			s.authCache.put(userID, alreadySubmitted, s.clock.Now())
+		}
+
+		if alreadySubmitted {
+			writeJSON(w, http.StatusForbidden, map[string]any{
+				"error":  "access_denied",
+				"reason": "account_already_submitted",
+			})
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	}
+}
+
+// optionalAuthMiddleware lets /events serve anonymous subscribers. A
+// request without an Authorization header goes through without claims;
+// one that carries a token is authenticated and gets its claims
+// attached, or is refused if the token is bad. Unlike authMiddleware it
+// does not check verification_status, which gates publishing, not
+// receiving events.
+func (s *Server) optionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+
+		claims, ok := s.authenticate(w, r)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	}
+}
+
+// adminMiddleware admits only tokens carrying the admin role. Admins skip
+// the verification_status check that gates regular users.
+func (s *Server) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := s.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		if claims.Role != roleAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	}
+}
+
+// refreshHandler issues a new token for the caller and revokes the one
+// presented. Expired tokens never reach here: jwt.ParseWithClaims rejects
+// them without leeway. Tokens without an exp claim are rejected explicitly
+// so they cannot be refreshed forever.
+func (s *Server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		unauthorized(w, authErrTokenMissing, "Unauthorized")
+		return
+	}
+
+	now := s.clock.Now()
+	if claims.ExpiresAt == nil || !now.Before(claims.ExpiresAt.Time) {
+		unauthorized(w, authErrTokenExpired, "Token expired")
+		return
+	}
+
+	expiresAt := now.Add(s.config.TokenTTL)
+	fresh := &Claims{
+		UserID:   claims.EffectiveUserID(),
+		Role:     claims.Role,
+		TenantID: claims.TenantID,
+		Extra:    claims.Extra,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   claims.Subject,
+			Issuer:    claims.Issuer,
+			Audience:  claims.Audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, fresh).SignedString(s.config.JwtSecret)
+	if err != nil {
+		s.logger.Error("Failed to sign token", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.rotateSession(r.Context(), claims.EffectiveUserID(), claims.ID, claims.ExpiresAt.Time, fresh.ID, expiresAt)
+	if err != nil {
+		s.logger.Error("Failed to rotate session", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"token":      signed,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+const logoutEventType = "logout"
+
+// logoutHandler revokes the caller's token and ends every stream the user
+// has open on this instance, each after a final logout event. Tokens
+// without a jti have no session to revoke and are refused, so logout
+// either fully happens or not at all.
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		unauthorized(w, authErrTokenMissing, "Unauthorized")
+		return
+	}
+	if claims.ID == "" {
+		http.Error(w, "Token has no jti and cannot be revoked", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := s.clock.Now()
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := s.revokeSession(r.Context(), claims.ID, expiresAt); err != nil {
+		s.logger.Error("Failed to revoke session", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	msg, _ := jsonMarshal(map[string]string{"event": logoutEventType})
+	n := s.disconnectUser(r.Context(), claims.EffectiveUserID(), eventFrame("", logoutEventType, msg))
+
+	s.logger.Info("User logged out", "user_id", claims.EffectiveUserID(), "streams_closed", n)
+	w.WriteHeader(http.StatusNoContent)
+}
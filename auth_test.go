@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuthenticatedRequestLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   slog.Level
+		claims  *Claims
+		wantLog []string
+	}{
+		{"info level", slog.LevelInfo, &Claims{UserID: 7}, nil},
+		{"debug level, user_id", slog.LevelDebug, &Claims{UserID: 7}, []string{`"user_id":7`, `"user_id_source":"user_id"`}},
+		{"debug level, sub", slog.LevelDebug, &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "42"}}, []string{`"user_id":42`, `"user_id_source":"sub"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: tt.level}))
+			s, _, _ := newTestServer(t, testConfig(), WithLogger(logger))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/events/history/00000000-0000-0000-0000-000000000000", nil)
+			r.Header.Set("Authorization", "Bearer "+signToken(t, tt.claims))
+			if w := serve(s, r); w.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want 404; body %s", w.Code, w.Body)
+			}
+
+			logged := strings.Contains(buf.String(), "Request authenticated")
+			if logged != (tt.wantLog != nil) {
+				t.Fatalf("logged = %v, want %v; log %s", logged, tt.wantLog != nil, buf.String())
+			}
+			for _, want := range tt.wantLog {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("log %s does not contain %s", buf.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestClaimsExtraRoundTrip(t *testing.T) {
+	type flags struct {
+		Beta bool `json:"beta"`
+	}
+	tests := []struct {
+		name   string
+		claims jwt.Claims
+		key    string
+		target func() any
+		want   any
+		wantOK bool
+	}{
+		{
+			name:   "string claim from another issuer",
+			claims: jwt.MapClaims{"user_id": 1, "plan": "pro", "exp": time.Now().Add(time.Hour).Unix()},
+			key:    "plan",
+			target: func() any { return new(string) },
+			want:   "pro",
+			wantOK: true,
+		},
+		{
+			name:   "number claim",
+			claims: jwt.MapClaims{"user_id": 1, "org_id": 42, "exp": time.Now().Add(time.Hour).Unix()},
+			key:    "org_id",
+			target: func() any { return new(int) },
+			want:   42,
+			wantOK: true,
+		},
+		{
+			name: "object claim signed from Claims.Extra",
+			claims: &Claims{UserID: 1, Extra: map[string]json.RawMessage{
+				"feature_flags": json.RawMessage(`{"beta":true}`),
+			}},
+			key:    "feature_flags",
+			target: func() any { return new(flags) },
+			want:   flags{Beta: true},
+			wantOK: true,
+		},
+		{
+			name:   "known claims stay out of Extra",
+			claims: &Claims{UserID: 1, Role: "admin"},
+			key:    "role",
+			target: func() any { return new(string) },
+		},
+		{
+			name:   "missing claim",
+			claims: &Claims{UserID: 1},
+			key:    "plan",
+			target: func() any { return new(string) },
+		},
+		{
+			name:   "claim of the wrong type",
+			claims: jwt.MapClaims{"user_id": 1, "plan": "pro", "exp": time.Now().Add(time.Hour).Unix()},
+			key:    "plan",
+			target: func() any { return new(int) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestServer(t, testConfig())
+			if c, ok := tt.claims.(*Claims); ok && c.ExpiresAt == nil {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tt.claims).SignedString([]byte(testJWTSecret))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got *Claims
+			h := s.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = ClaimsFromContext(r.Context())
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			h(w, r)
+			if got == nil {
+				t.Fatalf("no claims; status %d, body %s", w.Code, w.Body)
+			}
+			if got.UserID != 1 {
+				t.Errorf("UserID = %d, want 1", got.UserID)
+			}
+
+			target := tt.target()
+			if ok := got.GetExtra(tt.key, target); ok != tt.wantOK {
+				t.Fatalf("GetExtra(%q) = %v, want %v; Extra %s", tt.key, ok, tt.wantOK, got.Extra)
+			}
+			if tt.wantOK {
+				if v := reflect.ValueOf(target).Elem().Interface(); !reflect.DeepEqual(v, tt.want) {
+					t.Errorf("GetExtra(%q) = %#v, want %#v", tt.key, v, tt.want)
+				}
+			}
+
+			// Extra survives marshalling the claims again, as /auth/refresh
+			// does when it issues a new token.
+			data, err := got.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var again Claims
+			if err := json.Unmarshal(data, &again); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again.Extra, got.Extra) {
+				t.Errorf("Extra after a round trip = %s, want %s", again.Extra, got.Extra)
+			}
+		})
+	}
+}
+
+func TestInvalidTokensSkipDatabase(t *testing.T) {
+	// Every token carries a jti, so a check running out of order would
+	// also reach the sessions table.
+	withJTI := func(c *Claims) *Claims {
+		c.ID = "0b6d2d4e-6f0e-4a53-9a39-54c4f5a3b1a7"
+		return c
+	}
+	sign := func(method jwt.SigningMethod, key any, claims *Claims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	past := jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode string
+	}{
+		{name: "no header", wantCode: authErrTokenMissing},
+		{name: "wrong scheme", header: "Basic dXNlcjpwYXNz", wantCode: authErrInvalidToken},
+		{name: "malformed", header: "Bearer not.a.jwt", wantCode: authErrInvalidToken},
+		{name: "wrong secret", wantCode: authErrInvalidToken,
+			header: "Bearer " + sign(jwt.SigningMethodHS256, []byte("some-other-secret-that-is-long-enough"), withJTI(&Claims{UserID: 1}))},
+		{name: "alg none", wantCode: authErrInvalidToken,
+			header: "Bearer " + sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, withJTI(&Claims{UserID: 1}))},
+		{name: "expired", wantCode: authErrTokenExpired,
+			header: "Bearer " + signToken(t, withJTI(&Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: past}}))},
+		{name: "no user claim", wantCode: authErrInvalidClaims,
+			header: "Bearer " + signToken(t, withJTI(&Claims{}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, _ := newTestServer(t, testConfig())
+
+			r := httptest.NewRequest(http.MethodPost, "/trigger", strings.NewReader(`{"event_type":"notification","payload":{}}`))
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := serve(s, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want 401; body %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, `error="`+tt.wantCode+`"`) {
+				t.Errorf("WWW-Authenticate = %s, want error %s", got, tt.wantCode)
+			}
+			if queries := fake.Queries(); len(queries) != 0 {
+				t.Errorf("invalid token ran %d statements: %v", len(queries), queries)
+			}
+		})
+	}
+}
+
+// BenchmarkAuthMiddleware measures authMiddleware on its own, for a token
+// that fails verification, and for a valid one with and without the
+// verification status cached.
+func BenchmarkAuthMiddleware(b *testing.B) {
+	tests := []struct {
+		name     string
+		cacheTTL time.Duration
+		token    func(b *testing.B) string
+		wantCode int
+	}{
+		{name: "invalid signature", wantCode: http.StatusUnauthorized, token: func(b *testing.B) string {
+			return signToken(b, &Claims{UserID: 1}) + "x"
+		}},
+		{name: "valid, uncached", wantCode: http.StatusOK, token: func(b *testing.B) string {
+			return signToken(b, &Claims{UserID: 1})
+		}},
+		{name: "valid, cached", cacheTTL: time.Minute, wantCode: http.StatusOK, token: func(b *testing.B) string {
+			return signToken(b, &Claims{UserID: 1})
+		}},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			cfg := testConfig()
+			cfg.AuthCacheTTL = tt.cacheTTL
+			s, _, _ := newTestServer(b, cfg)
+			h := s.authMiddleware(func(w http.ResponseWriter, r *http.Request) {})
+			header := "Bearer " + tt.token(b)
+
+			b.ReportAllocs()
+			for b.Loop() {
+				r := httptest.NewRequest(http.MethodPost, "/trigger", nil)
+				r.Header.Set("Authorization", header)
+				w := httptest.NewRecorder()
+				h(w, r)
+				if w.Code != tt.wantCode {
+					b.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
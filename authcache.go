@@ -0,0 +1,127 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const authCacheSize = 1000
+
+// authInvalidateChannel is the NOTIFY channel the users trigger from
+// migration 000010 publishes a user's id on when their
+// verification_status changes.
+const authInvalidateChannel = "peeple_auth_invalidate"
+
+// authCache is an LRU of users' verification_status with a per-entry
+// TTL, so authMiddleware does not query users on every request.
+type authCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	order   *list.List // front is most recently used
+	entries map[uint64]*list.Element
+}
+
+type authCacheEntry struct {
+	userID    uint64
+	submitted bool
+	expires   time.Time
+}
+
+func newAuthCache(ttl time.Duration) *authCache {
+	return &authCache{ttl: ttl, order: list.New(), entries: make(map[uint64]*list.Element)}
+}
+
+func (c *authCache) get(userID uint64, now time.Time) (submitted, ok bool) {
+	if c.ttl <= 0 {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[userID]
+	if !ok {
+		return false, false
+	}
+	e := el.Value.(*authCacheEntry)
+	if now.After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, userID)
+		return false, false
+	}
+	c.order.MoveToFront(el)
+	return e.submitted, true
+}
+
+func (c *authCache) put(userID uint64, submitted bool, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[userID]; ok {
+		e := el.Value.(*authCacheEntry)
+		e.submitted, e.expires = submitted, now.Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[userID] = c.order.PushFront(&authCacheEntry{userID: userID, submitted: submitted, expires: now.Add(c.ttl)})
+	if c.order.Len() > authCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*authCacheEntry).userID)
+	}
+}
+
+func (c *authCache) invalidate(userID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[userID]; ok {
+		c.order.Remove(el)
+		delete(c.entries, userID)
+	}
+}
+
+func (c *authCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.entries)
+}
+
+// listenAuthInvalidations evicts users from the auth cache as soon as
+// their verification_status changes in the database. Every instance
+// listens, so the eviction applies everywhere. Changes made while the
+// listener was disconnected are missed, so the whole cache is dropped
+// each time it (re)connects.
+func (s *Server) listenAuthInvalidations(dsn string) {
+	s.listen(dsn, authInvalidateChannel, s.authCache.clear, func(_ context.Context, payload []byte) error {
+		id, err := strconv.ParseUint(string(payload), 10, 64)
+		if err != nil {
+			s.logger.Warn("Ignoring malformed auth invalidation", "payload", string(payload))
+			return nil
+		}
+		s.authCache.invalidate(id)
+		s.logger.Debug("Auth cache entry invalidated", "user_id", id)
+		return nil
+	})
+}
+
+// adminInvalidateCacheHandler drops the cached verification_status of
+// ?user_id= on this instance.
+func (s *Server) adminInvalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || id == 0 {
+		http.Error(w, "user_id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	s.authCache.invalidate(id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuthCache(t *testing.T) {
+	const ttl = 30 * time.Second
+
+	type step struct {
+		name string
+		// advance moves the clock before the request.
+		advance time.Duration
+		// invalidate calls /admin/invalidate-cache for user 1 first.
+		invalidate bool
+		// submitted is what the database says from this step on.
+		submitted  bool
+		wantStatus int
+		wantQuery  bool
+	}
+	steps := []step{
+		{name: "first request", wantStatus: http.StatusOK, wantQuery: true},
+		{name: "cached", advance: ttl / 2, wantStatus: http.StatusOK},
+		{name: "status changed but still cached", submitted: true, wantStatus: http.StatusOK},
+		{name: "entry expired", advance: ttl, submitted: true, wantStatus: http.StatusForbidden, wantQuery: true},
+		{name: "cached again", submitted: true, wantStatus: http.StatusForbidden},
+		{name: "invalidated", invalidate: true, wantStatus: http.StatusOK, wantQuery: true},
+		{name: "cached after invalidation", wantStatus: http.StatusOK},
+	}
+
+	cfg := testConfig()
+	cfg.AuthCacheTTL = ttl
+	s, fake, clock := newTestServer(t, cfg)
+	var submitted atomic.Bool
+	var lookups atomic.Int64
+	fake.setHandler(func(q fakeQuery) fakeResult {
+		if strings.Contains(q.SQL, "verification_status FROM users") {
+			if q.Args[0] == int64(1) {
+				lookups.Add(1)
+				return fakeRow(submitted.Load())
+			}
+			return fakeRow(false)
+		}
+		return fakeResult{}
+	})
+
+	for _, st := range steps {
+		clock.Advance(st.advance)
+		submitted.Store(st.submitted)
+		if st.invalidate {
+			r := authRequest(t, http.MethodPost, "/admin/invalidate-cache?user_id=1", 0, nil)
+			r.Header.Set("Authorization", "Bearer "+signToken(t, &Claims{UserID: 2, Role: roleAdmin}))
+			if w := serve(s, r); w.Code != http.StatusNoContent {
+				t.Fatalf("%s: invalidate: status %d, body %s", st.name, w.Code, w.Body)
+			}
+		}
+
+		before := lookups.Load()
+		w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1, strings.NewReader(`{"event_type":"notification","payload":{}}`)))
+		if w.Code != st.wantStatus {
+			t.Errorf("%s: status = %d, want %d; body %s", st.name, w.Code, st.wantStatus, w.Body)
+		}
+		if queried := lookups.Load() > before; queried != st.wantQuery {
+			t.Errorf("%s: queried the database = %v, want %v", st.name, queried, st.wantQuery)
+		}
+	}
+}
+
+func TestAuthCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newAuthCache(time.Minute)
+	now := time.Now()
+	for id := uint64(1); id <= authCacheSize; id++ {
+		c.put(id, false, now)
+	}
+	// Touching user 1 makes user 2 the least recently used.
+	if _, ok := c.get(1, now); !ok {
+		t.Fatal("user 1 not cached")
+	}
+	c.put(authCacheSize+1, false, now)
+
+	if _, ok := c.get(2, now); ok {
+		t.Error("user 2 still cached past the size limit")
+	}
+	for _, id := range []uint64{1, 3, authCacheSize + 1} {
+		if _, ok := c.get(id, now); !ok {
+			t.Errorf("user %d evicted", id)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultChannel is used by clients and triggers that do not name one.
+const defaultChannel = "default"
+
+// ClientMeta describes a connected SSE client.
+type ClientMeta struct {
+	ConnID    string
+	SessionID string
+	// ResumedFrom is the session the client reported in X-Session-ID when
+	// reconnecting, if any.
+	ResumedFrom string
+	UserID      uint64
+	Channel     string
+	// Format is the encoding the client asked for: "sse", "ndjson" or
+	// "long_poll", or "simulated" for clients made by
+	// /admin/simulate-slow-client.
+	Format      string
+	RemoteAddr  string
+	ConnectedAt time.Time
+	// Logger carries the fields above on every line it writes.
+	Logger *slog.Logger
+
+	// messagesSent counts broadcast frames written to the stream, not
+	// counting the connected event and pings.
+	messagesSent atomic.Int64
+
+	// closeOnce guards the close of the client's channel; see safeClose.
+	closeOnce sync.Once
+	// sendMu is held for reading by every send to the client's channel
+	// and for writing by safeClose, so the channel is never closed under
+	// a send. gone, made on first use, is closed just before, so a send
+	// waiting for room gives up instead of holding the close back.
+	sendMu   sync.RWMutex
+	gone     chan struct{}
+	goneOnce sync.Once
+
+	// kick is closed by disconnect to make the client's stream return.
+	kick     chan struct{}
+	kickOnce sync.Once
+
+	// wake, if set, is the channel of the pool worker serving the
+	// client's stream. It is signalled whenever a frame is queued for the
+	// client and when the client is kicked or removed. It must be set
+	// before the client is registered.
+	wake chan struct{}
+
+	// limiter paces writes to this client. While it waits, broadcasts
+	// queue in the client's buffer and the backpressure strategy applies
+	// once that is full. Nil means unlimited.
+	limiter *tokenBucket
+}
+
+// safeClose closes ch, the channel registered with m, at most once no
+// matter how many cleanup paths reach it.
+func (m *ClientMeta) safeClose(ch chan *Frame) {
+	m.closeOnce.Do(func() {
+		close(m.goneCh())
+		m.sendMu.Lock()
+		close(ch)
+		m.sendMu.Unlock()
+	})
+	m.notify()
+}
+
+func (m *ClientMeta) goneCh() chan struct{} {
+	m.goneOnce.Do(func() { m.gone = make(chan struct{}) })
+	return m.gone
+}
+
+// disconnect asks the client's stream to end once it has written what is
+// already queued for it.
+func (m *ClientMeta) disconnect() {
+	if m.kick != nil {
+		m.kickOnce.Do(func() { close(m.kick) })
+	}
+	m.notify()
+}
+
+// notify signals wake without blocking. A signal already pending covers
+// this one too.
+func (m *ClientMeta) notify() {
+	if m.wake == nil {
+		return
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// disconnectUser sends frame to every client of userID, then disconnects
+// them. It returns how many clients it reached.
+func (s *Server) disconnectUser(ctx context.Context, userID uint64, frame *Frame) int {
+	return s.eachUserClient(userID, func(c clientEntry) {
+		s.send(ctx, c, frame)
+		c.meta.disconnect()
+	})
+}
+
+// eachUserClient calls fn for every client of userID, after releasing
+// the registry, and returns how many there were.
+func (s *Server) eachUserClient(userID uint64, fn func(c clientEntry)) int {
+	var matched []clientEntry
+	s.clients.View(func(clients []clientEntry) {
+		for _, c := range clients {
+			if c.meta.UserID == userID {
+				matched = append(matched, c)
+			}
+		}
+	})
+	for _, c := range matched {
+		fn(c)
+	}
+	return len(matched)
+}
+
+// delivery records the outcome of one send so it can be logged after the
+// registry is released.
+type delivery struct {
+	meta       *ClientMeta
+	queueDepth int
+	dropped    bool
+}
+
+// BackpressureStrategy decides what broadcast does with a client whose
+// buffer is full.
+type BackpressureStrategy string
+
+const (
+	// BackpressureDrop drops the message for that client immediately.
+	BackpressureDrop BackpressureStrategy = "drop"
+	// BackpressureBlock waits up to BackpressureTimeout for room, then
+	// drops.
+	BackpressureBlock BackpressureStrategy = "block"
+	// BackpressureError drops like BackpressureDrop but reports the
+	// affected users to the caller so it can retry.
+	BackpressureError BackpressureStrategy = "error"
+)
+
+// DroppedClientsError lists the users a BackpressureError broadcast
+// could not reach.
+type DroppedClientsError struct {
+	UserIDs []uint64
+}
+
+func (e *DroppedClientsError) Error() string {
+	return fmt.Sprintf("message dropped for %d slow client(s)", len(e.UserIDs))
+}
+
+// eventFrame builds the frame for a stored event. Events of the legacy
+// trigger type go out unnamed so clients listening with onmessage keep
+// receiving them; every other type is sent as a named event.
+func eventFrame(eventID, eventType string, msg []byte) *Frame {
+	f := &Frame{ID: eventID, Data: msg}
+	if eventType != legacyEventType {
+		f.Event = eventType
+	}
+	return f
+}
+
+// frameEventType is the event type frame is routed and logged under.
+func frameEventType(frame *Frame) string {
+	if frame.Event == "" {
+		return legacyEventType
+	}
+	return frame.Event
+}
+
+// broadcast sends frame to every connected client regardless of channel.
+// The counts are summed over all channels.
+func (s *Server) broadcast(ctx context.Context, frame *Frame) (delivered, dropped int, err error) {
+	return s.deliver(ctx, nil, frame)
+}
+
+// broadcastToChannel sends frame to subscribers of channel and of every
+// channel a routing rule for the frame's event type points at.
+func (s *Server) broadcastToChannel(ctx context.Context, channel string, frame *Frame) (delivered, dropped int, err error) {
+	stats := s.channelStatsFor(channel)
+	stats.broadcast.Add(1)
+
+	start := time.Now()
+	delivered, dropped, err = s.deliver(ctx, s.routing.Targets(channel, frameEventType(frame)), frame)
+	stats.observeLatency(time.Since(start))
+	return delivered, dropped, err
+}
+
+// BroadcastBytes sends payload as an eventType event on channel, following
+// routing rules like /trigger does, and returns how many clients it was
+// delivered to and dropped for. It is meant for code embedding Server.
+func (s *Server) BroadcastBytes(channel, eventType string, payload []byte) (int, int) {
+	delivered, dropped, _ := s.broadcastToChannel(context.Background(), channel, eventFrame(uuid.NewString(), eventType, payload))
+	return delivered, dropped
+}
+
+// BroadcastJSON marshals v and sends it with BroadcastBytes. A value that
+// cannot be marshalled is logged and reaches nobody.
+func (s *Server) BroadcastJSON(channel, eventType string, v any) (int, int) {
+	payload, err := jsonMarshal(v)
+	if err != nil {
+		s.logger.Error("Failed to marshal broadcast payload", "event_type", eventType, "error", err)
+		return 0, 0
+	}
+	return s.BroadcastBytes(channel, eventType, payload)
+}
+
+// deliver fans frame out to clients subscribed to one of channels, or to
+// all clients when channels is nil, and reports how many clients got it
+// and how many it was dropped for. It returns a *DroppedClientsError only
+// under BackpressureError.
+func (s *Server) deliver(ctx context.Context, channels map[string]bool, frame *Frame) (delivered, dropped int, err error) {
+	debug := s.logger.Enabled(ctx, slog.LevelDebug)
+	logger := s.logger.With("event_type", frameEventType(frame))
+
+	// The sends happen after View returns, so a slow client never holds
+	// up connects and disconnects.
+	var clients []clientEntry
+	s.clients.View(func(all []clientEntry) {
+		if channels == nil {
+			clients = all
+			return
+		}
+		for _, c := range all {
+			if channels[c.meta.Channel] {
+				clients = append(clients, c)
+			}
+		}
+	})
+
+	var results []delivery
+	if s.broadcastWorkers <= 1 || len(clients) <= s.broadcastWorkers {
+		results, delivered = s.fanOut(ctx, clients, frame, debug)
+	} else {
+		results, delivered = s.fanOutParallel(ctx, clients, frame, debug)
+	}
+
+	s.totalBroadcasts.Add(1)
+	var droppedUsers []uint64
+	for _, d := range results {
+		if d.dropped {
+			droppedUsers = append(droppedUsers, d.meta.UserID)
+			s.channelStatsFor(d.meta.Channel).dropped.Add(1)
+		}
+		attrs := []any{"channel", d.meta.Channel, "user_id", d.meta.UserID, "queue_depth", d.queueDepth}
+		if d.dropped {
+			logger.Warn("Dropping message for slow client", attrs...)
+		} else {
+			logger.Debug("Delivered message to client", attrs...)
+		}
+	}
+
+	dropped = len(droppedUsers)
+	s.totalDropped.Add(int64(dropped))
+	s.metrics.messagesDelivered.Add(float64(delivered))
+	s.metrics.messagesDropped.Add(float64(dropped))
+
+	if s.config.BackpressureStrategy == BackpressureError && dropped > 0 {
+		return delivered, dropped, &DroppedClientsError{UserIDs: droppedUsers}
+	}
+	return delivered, dropped, nil
+}
+
+// fanOut sends frame to every client and returns the deliveries worth
+// logging, drops always and successful sends only when debug is set,
+// along with the number of successful sends.
+func (s *Server) fanOut(ctx context.Context, clients []clientEntry, frame *Frame, debug bool) ([]delivery, int) {
+	var (
+		results   []delivery
+		delivered int
+	)
+	for _, c := range clients {
+		d := s.send(ctx, c, frame)
+		if !d.dropped {
+			delivered++
+		}
+		if d.dropped || debug {
+			results = append(results, d)
+		}
+	}
+	return results, delivered
+}
+
+// fanOutParallel splits the fan-out across s.broadcastWorkers goroutines.
+func (s *Server) fanOutParallel(ctx context.Context, clients []clientEntry, frame *Frame, debug bool) ([]delivery, int) {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		results   []delivery
+		delivered int
+	)
+	chunk := (len(clients) + s.broadcastWorkers - 1) / s.broadcastWorkers
+	for start := 0; start < len(clients); start += chunk {
+		end := min(start+chunk, len(clients))
+		wg.Add(1)
+		go func(part []clientEntry) {
+			defer wg.Done()
+			local, n := s.fanOut(ctx, part, frame, debug)
+			mu.Lock()
+			results = append(results, local...)
+			delivered += n
+			mu.Unlock()
+		}(clients[start:end])
+	}
+	wg.Wait()
+
+	return results, delivered
+}
+
+// send tries a non-blocking send first, then waits for room for up to
+// sendTimeout. It runs outside the registry, so every slow client adds
+// that long to the broadcast but not to connects and disconnects.
+// Cancelling ctx or removing the client ends the wait early.
+//
+// The wait is a select on channels, which parks the goroutine rather
+// than its OS thread, so other requests keep running even with
+// GOMAXPROCS=1. Nothing here may call runtime.LockOSThread or make a
+// blocking syscall while holding the client's sendMu.
+func (s *Server) send(ctx context.Context, c clientEntry, frame *Frame) delivery {
+	c.meta.sendMu.RLock()
+	defer c.meta.sendMu.RUnlock()
+
+	gone := c.meta.goneCh()
+	select {
+	case <-gone:
+		return delivery{meta: c.meta, queueDepth: len(c.ch), dropped: true}
+	default:
+	}
+
+	select {
+	case c.ch <- frame:
+		c.meta.notify()
+		return delivery{meta: c.meta, queueDepth: len(c.ch)}
+	default:
+	}
+
+	if wait := s.sendTimeout(); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case c.ch <- frame:
+			c.meta.notify()
+			return delivery{meta: c.meta, queueDepth: len(c.ch)}
+		case <-timer.C:
+		case <-ctx.Done():
+		case <-gone:
+		}
+	}
+
+	return delivery{meta: c.meta, queueDepth: len(c.ch), dropped: true}
+}
+
+// sendTimeout is how long send waits for room in a full buffer before
+// giving up on the client. BackpressureBlock always waits
+// BackpressureTimeout; the other strategies wait BroadcastSendTimeout,
+// which is zero unless configured.
+func (s *Server) sendTimeout() time.Duration {
+	if s.config.BackpressureStrategy == BackpressureBlock {
+		return s.config.BackpressureTimeout
+	}
+	return s.config.BroadcastSendTimeout
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registryKinds are the clientRegistry implementations benchmarks compare,
+// by their USE_SYNC_MAP setting.
+var registryKinds = []struct {
+	name       string
+	useSyncMap bool
+}{
+	{"mutex", false},
+	{"syncmap", true},
+}
+
+// addClients registers n clients of channel with buffers of size buf and
+// returns their channels.
+func addClients(s *Server, n int, channel string, buf int) []chan *Frame {
+	chans := make([]chan *Frame, n)
+	for i := range chans {
+		chans[i] = make(chan *Frame, buf)
+		s.clients.Add(chans[i], &ClientMeta{UserID: uint64(i + 1), Channel: channel, kick: make(chan struct{})})
+	}
+	return chans
+}
+
+func TestBackpressureStrategies(t *testing.T) {
+	tests := []struct {
+		name          string
+		strategy      BackpressureStrategy
+		timeout       time.Duration
+		drainAfter    time.Duration
+		wantDelivered int
+		wantDropped   []uint64
+		wantErr       bool
+		minElapsed    time.Duration
+	}{
+		{name: "drop", strategy: BackpressureDrop, timeout: time.Second, wantDelivered: 1, wantDropped: []uint64{2}},
+		{name: "block until room", strategy: BackpressureBlock, timeout: 5 * time.Second, drainAfter: 20 * time.Millisecond, wantDelivered: 2},
+		{name: "block until timeout", strategy: BackpressureBlock, timeout: 50 * time.Millisecond, wantDelivered: 1, wantDropped: []uint64{2}, minElapsed: 50 * time.Millisecond},
+		{name: "error", strategy: BackpressureError, timeout: time.Second, wantDelivered: 1, wantDropped: []uint64{2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.BackpressureStrategy = tt.strategy
+			cfg.BackpressureTimeout = tt.timeout
+			s, _, _ := newTestServer(t, cfg)
+
+			// Client 1 has room; client 2's buffer is already full.
+			chans := addClients(s, 2, defaultChannel, 1)
+			stale := eventFrame("stale", legacyEventType, []byte(`{}`))
+			chans[1] <- stale
+			if tt.drainAfter > 0 {
+				go func() {
+					time.Sleep(tt.drainAfter)
+					<-chans[1]
+				}()
+			}
+
+			start := time.Now()
+			frame := eventFrame("id", legacyEventType, []byte(`{"number":1}`))
+			delivered, dropped, err := s.broadcastToChannel(context.Background(), defaultChannel, frame)
+			elapsed := time.Since(start)
+
+			if delivered != tt.wantDelivered || dropped != len(tt.wantDropped) {
+				t.Errorf("delivered, dropped = %d, %d, want %d, %d", delivered, dropped, tt.wantDelivered, len(tt.wantDropped))
+			}
+			if elapsed < tt.minElapsed {
+				t.Errorf("broadcast returned after %v, want at least %v", elapsed, tt.minElapsed)
+			}
+			if tt.strategy != BackpressureBlock && elapsed >= tt.timeout {
+				t.Errorf("%s waited %v for the slow client", tt.strategy, elapsed)
+			}
+
+			var dropErr *DroppedClientsError
+			switch {
+			case !tt.wantErr && err != nil:
+				t.Errorf("err = %v, want nil", err)
+			case tt.wantErr && !errors.As(err, &dropErr):
+				t.Errorf("err = %v, want *DroppedClientsError", err)
+			case tt.wantErr && !slices.Equal(dropErr.UserIDs, tt.wantDropped):
+				t.Errorf("UserIDs = %v, want %v", dropErr.UserIDs, tt.wantDropped)
+			}
+
+			if got := <-chans[0]; got != frame {
+				t.Errorf("fast client got %+v", got)
+			}
+			if len(tt.wantDropped) > 0 {
+				if got := <-chans[1]; got != stale {
+					t.Errorf("slow client got %+v, want only the stale frame", got)
+				}
+			}
+		})
+	}
+}
+
+func TestTriggerReportsDroppedClients(t *testing.T) {
+	cfg := testConfig()
+	cfg.BackpressureStrategy = BackpressureError
+	s, _, _ := newTestServer(t, cfg)
+	chans := addClients(s, 3, defaultChannel, 1)
+	chans[2] <- eventFrame("stale", legacyEventType, []byte(`{}`))
+
+	w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+		strings.NewReader(`{"event_type":"notification","payload":{}}`)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503; body %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		Error     string   `json:"error"`
+		EventID   string   `json:"event_id"`
+		UserIDs   []uint64 `json:"user_ids"`
+		Delivered int      `json:"delivered"`
+		Dropped   int      `json:"dropped"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if body.Error != "clients_dropped" || body.EventID == "" || !slices.Equal(body.UserIDs, []uint64{3}) ||
+		body.Delivered != 2 || body.Dropped != 1 {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestBroadcastSendTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		timeout       time.Duration
+		drainAfter    time.Duration
+		cancelAfter   time.Duration
+		wantDelivered int
+		minElapsed    time.Duration
+		maxElapsed    time.Duration
+	}{
+		{name: "disabled", wantDelivered: 1, maxElapsed: 20 * time.Millisecond},
+		{name: "room within the timeout", timeout: 5 * time.Second, drainAfter: 20 * time.Millisecond, wantDelivered: 2, maxElapsed: time.Second},
+		{name: "no room within the timeout", timeout: 50 * time.Millisecond, wantDelivered: 1, minElapsed: 50 * time.Millisecond, maxElapsed: time.Second},
+		{name: "cancelled while waiting", timeout: 5 * time.Second, cancelAfter: 20 * time.Millisecond, wantDelivered: 1, maxElapsed: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.BroadcastSendTimeout = tt.timeout
+			s, _, _ := newTestServer(t, cfg)
+
+			// Client 1 has room; client 2's buffer is already full.
+			chans := addClients(s, 2, defaultChannel, 1)
+			chans[1] <- eventFrame("stale", legacyEventType, []byte(`{}`))
+			if tt.drainAfter > 0 {
+				go func() {
+					time.Sleep(tt.drainAfter)
+					<-chans[1]
+				}()
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			frame := eventFrame("id", legacyEventType, []byte(`{"number":1}`))
+			delivered, dropped, _ := s.broadcastToChannel(ctx, defaultChannel, frame)
+			elapsed := time.Since(start)
+
+			if delivered != tt.wantDelivered || dropped != 2-tt.wantDelivered {
+				t.Errorf("delivered, dropped = %d, %d, want %d, %d", delivered, dropped, tt.wantDelivered, 2-tt.wantDelivered)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("broadcast took %v, want between %v and %v", elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
+
+// TestBroadcastSendTimeoutOutsideRegistry checks that a broadcast waiting
+// on a slow client leaves the registry free, and stops waiting once that
+// client is removed.
+func TestBroadcastSendTimeoutOutsideRegistry(t *testing.T) {
+	for _, kind := range registryKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.UseSyncMap = kind.useSyncMap
+			cfg.BroadcastSendTimeout = 5 * time.Second
+			s, _, _ := newTestServer(t, cfg)
+			slow := addClients(s, 1, defaultChannel, 1)[0]
+			slow <- eventFrame("stale", legacyEventType, []byte(`{}`))
+
+			done := make(chan int)
+			go func() {
+				_, dropped, _ := s.broadcastToChannel(context.Background(), defaultChannel, eventFrame("id", legacyEventType, []byte(`{}`)))
+				done <- dropped
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			churned := make(chan struct{})
+			go func() {
+				ch := make(chan *Frame, 1)
+				s.clients.Add(ch, &ClientMeta{UserID: 2, Channel: "other"})
+				s.clients.Remove(ch)
+				close(churned)
+			}()
+			select {
+			case <-churned:
+			case <-time.After(time.Second):
+				t.Fatal("connect and disconnect waited for the broadcast")
+			}
+
+			s.clients.Remove(slow)
+			select {
+			case dropped := <-done:
+				if dropped != 1 {
+					t.Errorf("dropped = %d, want 1", dropped)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("broadcast kept waiting for a removed client")
+			}
+		})
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	for _, kind := range registryKinds {
+		for _, n := range []int{1_000, 10_000, 100_000} {
+			b.Run(fmt.Sprintf("%s/%d", kind.name, n), func(b *testing.B) {
+				cfg := testConfig()
+				cfg.UseSyncMap = kind.useSyncMap
+				s, _, _ := newTestServer(b, cfg)
+				chans := addClients(s, n, defaultChannel, 1)
+				frame := eventFrame("id", legacyEventType, []byte(`{"number":1}`))
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for b.Loop() {
+					delivered, _, _ := s.broadcastToChannel(context.Background(), defaultChannel, frame)
+					if delivered != n {
+						b.Fatalf("delivered to %d of %d clients", delivered, n)
+					}
+
+					b.StopTimer()
+					for _, ch := range chans {
+						<-ch
+					}
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBroadcastSendTimeout broadcasts to 1k clients of which half
+// never have room, showing the cost of BROADCAST_SEND_TIMEOUT_MS: each
+// slow client adds the whole timeout to every broadcast.
+func BenchmarkBroadcastSendTimeout(b *testing.B) {
+	const n = 1_000
+	for _, timeout := range []time.Duration{0, 100 * time.Microsecond, time.Millisecond} {
+		b.Run("timeout="+timeout.String(), func(b *testing.B) {
+			cfg := testConfig()
+			cfg.BroadcastSendTimeout = timeout
+			s, _, _ := newTestServer(b, cfg)
+			chans := addClients(s, n, defaultChannel, 1)
+			stale := eventFrame("stale", legacyEventType, []byte(`{}`))
+			for _, ch := range chans[n/2:] {
+				ch <- stale
+			}
+			frame := eventFrame("id", legacyEventType, []byte(`{"number":1}`))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for b.Loop() {
+				delivered, _, _ := s.broadcastToChannel(context.Background(), defaultChannel, frame)
+				if delivered != n/2 {
+					b.Fatalf("delivered to %d of %d clients", delivered, n/2)
+				}
+
+				b.StopTimer()
+				for _, ch := range chans[:n/2] {
+					<-ch
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// TestBroadcastWithOneProc runs 100 concurrent triggers on a single P
+// while a slow client makes every send wait out BroadcastSendTimeout.
+// The waits park goroutines rather than the only thread, so the triggers
+// finish side by side and each still reaches the client with room.
+func TestBroadcastWithOneProc(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	const triggers = 100
+	cfg := testConfig()
+	cfg.BroadcastSendTimeout = 20 * time.Millisecond
+	s, _, _ := newTestServer(t, cfg)
+	// The fast client has room for every event, so none of its sends waits;
+	// the slow one is full from the start, so every send to it times out.
+	fast := addClients(s, 1, defaultChannel, triggers)[0]
+	slow := make(chan *Frame, 1)
+	slow <- eventFrame("stale", legacyEventType, []byte(`{}`))
+	s.clients.Add(slow, &ClientMeta{UserID: 2, Channel: defaultChannel, kick: make(chan struct{})})
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, triggers)
+	for i := range triggers {
+		wg.Go(func() {
+			responses[i] = serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+				strings.NewReader(`{"event_type":"notification","payload":{}}`)))
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("triggers did not finish with GOMAXPROCS=1")
+	}
+
+	for i, w := range responses {
+		var body struct {
+			Delivered int `json:"delivered"`
+			Dropped   int `json:"dropped"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil ||
+			body.Delivered != 1 || body.Dropped != 1 {
+			t.Fatalf("trigger %d: status %d, body %s; want 200 delivered to 1 and dropped for 1", i, w.Code, w.Body)
+		}
+	}
+	if got := len(fast); got != triggers {
+		t.Errorf("fast client holds %d events, want %d", got, triggers)
+	}
+	if f := <-slow; f.ID != "stale" || len(slow) != 0 {
+		t.Errorf("slow client got events it had no room for")
+	}
+}
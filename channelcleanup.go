@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+const (
+	channelCleanupInterval  = 5 * time.Minute
+	channelPresenceInterval = time.Minute
+	channelUpdateTimeout    = 5 * time.Second
+)
+
+// A dynamic channel's cleanup_at is a lease: every instance pushes it to
+// NOW() + ChannelIdleTTL for the channels it has subscribers on, in one
+// background statement per presenceInterval, so connects and disconnects
+// never touch the database. Once no instance has subscribers the lease
+// stops being renewed and the channel is deleted when it runs out.
+
+// presenceInterval is how often runChannelPresence renews leases, often
+// enough that a channel in use never sees its lease run out.
+func (s *Server) presenceInterval() time.Duration {
+	if half := s.config.ChannelIdleTTL / 2; half > 0 && half < channelPresenceInterval {
+		return half
+	}
+	return channelPresenceInterval
+}
+
+// runChannelPresence renews the leases of channels with local subscribers
+// until the server shuts down.
+func (s *Server) runChannelPresence() {
+	ticker := time.NewTicker(s.presenceInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.renewChannelPresence(s.ctx); err != nil {
+				s.logger.Error("Failed to renew channel presence", "error", err)
+			}
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// renewChannelPresence extends the lease of every dynamic channel this
+// instance has subscribers on.
+func (s *Server) renewChannelPresence(ctx context.Context) error {
+	channels := s.localChannels()
+	if len(channels) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, channelUpdateTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE channels SET cleanup_at = NOW() + make_interval(secs => $2) WHERE name = ANY($1) AND NOT is_static",
+		channels, s.config.ChannelIdleTTL.Seconds())
+	return err
+}
+
+// localChannels returns the channels with subscribers on this instance,
+// sorted.
+func (s *Server) localChannels() []string {
+	var channels []string
+	s.clients.View(func(clients []clientEntry) {
+		for _, c := range clients {
+			channels = append(channels, c.meta.Channel)
+		}
+	})
+	slices.Sort(channels)
+	return slices.Compact(channels)
+}
+
+// runChannelCleanup deletes dynamic channels whose cleanup_at has passed
+// and that still have no subscribers here, and drops idle rate limit
+// buckets.
+func (s *Server) runChannelCleanup() {
+	ticker := time.NewTicker(channelCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.evictIdleBuckets(s.clock.Now())
+			if err := s.cleanupChannels(s.ctx); err != nil {
+				s.logger.Error("Channel cleanup failed", "error", err)
+			}
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// cleanupChannels deletes channels whose lease ran out more than a
+// presence interval ago. The margin leaves an instance whose first client
+// subscribed just before the lease ran out time to renew it.
+func (s *Server) cleanupChannels(ctx context.Context) error {
+	grace := s.presenceInterval().Seconds()
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT name FROM channels WHERE NOT is_static AND cleanup_at < NOW() - make_interval(secs => $1)", grace)
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		expired = append(expired, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range expired {
+		if s.SubscriberCount(name) > 0 {
+			continue
+		}
+		res, err := s.db.ExecContext(ctx,
+			"DELETE FROM channels WHERE name = $1 AND NOT is_static AND cleanup_at < NOW() - make_interval(secs => $2)", name, grace)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			s.forgetChannel(name)
+			s.logger.Info("Deleted idle channel", "channel", name)
+		}
+	}
+	return nil
+}
+
+// forgetChannel drops what this instance keeps about a deleted channel,
+// so a busy service that creates many short-lived channels does not
+// accumulate their stats. Rate limit state goes with the next
+// evictIdleBuckets once it is idle.
+func (s *Server) forgetChannel(name string) {
+	s.channels.remove(name)
+	s.channelStats.Delete(name)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseTable stands in for the channels table, evaluating the lease
+// statements of channelcleanup.go with clock as NOW().
+type leaseTable struct {
+	clock *fakeClock
+
+	mu        sync.Mutex
+	cleanupAt map[string]time.Time
+}
+
+func (lt *leaseTable) handle(q fakeQuery) fakeResult {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	now := lt.clock.Now()
+	secs := func(v driver.Value) time.Duration { return time.Duration(v.(float64) * float64(time.Second)) }
+	switch {
+	case strings.HasPrefix(q.SQL, "INSERT INTO channels"):
+		if q.Args[4] != nil {
+			lt.cleanupAt[q.Args[0].(string)] = now.Add(secs(q.Args[4]))
+		}
+		return fakeRow(now)
+	case strings.HasPrefix(q.SQL, "UPDATE channels SET cleanup_at"):
+		var n int64
+		for _, name := range q.Args[0].([]string) {
+			if _, ok := lt.cleanupAt[name]; ok {
+				lt.cleanupAt[name] = now.Add(secs(q.Args[1]))
+				n++
+			}
+		}
+		return fakeResult{RowsAffected: n}
+	case strings.HasPrefix(q.SQL, "SELECT name FROM channels"):
+		res := fakeResult{Columns: []string{"name"}}
+		for name, at := range lt.cleanupAt {
+			if at.Before(now.Add(-secs(q.Args[0]))) {
+				res.Rows = append(res.Rows, []driver.Value{name})
+			}
+		}
+		return res
+	case strings.HasPrefix(q.SQL, "DELETE FROM channels"):
+		name := q.Args[0].(string)
+		if at, ok := lt.cleanupAt[name]; ok && at.Before(now.Add(-secs(q.Args[1]))) {
+			delete(lt.cleanupAt, name)
+			return fakeResult{RowsAffected: 1}
+		}
+		return fakeResult{}
+	}
+	return unverifiedUsers(q)
+}
+
+func (lt *leaseTable) has(name string) bool {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	_, ok := lt.cleanupAt[name]
+	return ok
+}
+
+func TestChannelCleanup(t *testing.T) {
+	const ttl = time.Hour
+
+	tests := []struct {
+		name string
+		// subscribed says whether the channel keeps a local subscriber
+		// for the whole test.
+		subscribed bool
+		// renewedEvery is how often another instance renews the lease,
+		// zero when none does.
+		renewedEvery time.Duration
+		idle         time.Duration
+		wantDeleted  bool
+	}{
+		{name: "idle past the ttl", idle: ttl + 2*channelPresenceInterval, wantDeleted: true},
+		{name: "idle within the ttl", idle: ttl - time.Minute},
+		{name: "idle within the grace period", idle: ttl + channelPresenceInterval/2},
+		{name: "local subscriber", subscribed: true, idle: 3 * ttl},
+		{name: "subscriber on another instance", renewedEvery: channelPresenceInterval, idle: 3 * ttl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ChannelIdleTTL = ttl
+			s, fake, clock := newTestServer(t, cfg)
+			table := &leaseTable{clock: clock, cleanupAt: map[string]time.Time{"room": clock.Now().Add(ttl)}}
+			fake.setHandler(table.handle)
+			s.channels.add(ChannelInfo{Name: "room", Visibility: visibilityPublic})
+			s.channelStatsFor("room").broadcast.Add(1)
+
+			if tt.subscribed {
+				addClients(s, 1, "room", 1)
+			}
+			for elapsed := time.Duration(0); elapsed < tt.idle; elapsed += channelPresenceInterval {
+				clock.Advance(channelPresenceInterval)
+				if err := s.renewChannelPresence(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if tt.renewedEvery > 0 {
+					table.mu.Lock()
+					table.cleanupAt["room"] = clock.Now().Add(ttl)
+					table.mu.Unlock()
+				}
+			}
+
+			if err := s.cleanupChannels(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if deleted := !table.has("room"); deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if _, known := s.channels.Get("room"); known == tt.wantDeleted {
+				t.Errorf("registry knows the channel = %v after deleted = %v", known, tt.wantDeleted)
+			}
+			if _, kept := s.channelStats.Load("room"); kept == tt.wantDeleted {
+				t.Errorf("stats kept = %v after deleted = %v", kept, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestCleanupOfUnusedChannels(t *testing.T) {
+	const ttl = time.Hour
+
+	tests := []struct {
+		name        string
+		admin       bool
+		idle        time.Duration
+		wantDeleted bool
+	}{
+		{name: "dynamic past the ttl", idle: ttl + 2*channelPresenceInterval, wantDeleted: true},
+		{name: "dynamic within the ttl", idle: ttl - time.Minute},
+		{name: "static", admin: true, idle: 3 * ttl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ChannelIdleTTL = ttl
+			s, fake, clock := newTestServer(t, cfg)
+			table := &leaseTable{clock: clock, cleanupAt: make(map[string]time.Time)}
+			fake.setHandler(table.handle)
+
+			r := authRequest(t, http.MethodPost, "/channels", 1, strings.NewReader(`{"name":"room"}`))
+			if tt.admin {
+				r.Header.Set("Authorization", "Bearer "+signToken(t, &Claims{UserID: 1, Role: roleAdmin}))
+			}
+			if w := serve(s, r); w.Code != http.StatusCreated {
+				t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+			}
+			if leased := table.has("room"); leased == tt.admin {
+				t.Fatalf("cleanup_at set = %v for admin = %v", leased, tt.admin)
+			}
+
+			clock.Advance(tt.idle)
+			if err := s.cleanupChannels(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if _, known := s.channels.Get("room"); known == tt.wantDeleted {
+				t.Errorf("registry knows the channel = %v, want deleted = %v", known, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestRenewChannelPresence(t *testing.T) {
+	s, fake, _ := newTestServer(t, testConfig())
+
+	if err := s.renewChannelPresence(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.countQueries("channels"); n != 0 {
+		t.Fatalf("renewing without subscribers ran %d statements", n)
+	}
+
+	addClients(s, 2, "b", 1)
+	addClients(s, 1, "a", 1)
+	if err := s.renewChannelPresence(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	queries := fake.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("ran %d statements, want one: %v", len(queries), queries)
+	}
+	if got := queries[0].Args[0]; !slices.Equal(got.([]string), []string{"a", "b"}) {
+		t.Errorf("renewed %v, want [a b]", got)
+	}
+	if got, want := queries[0].Args[1], testConfig().ChannelIdleTTL.Seconds(); got != want {
+		t.Errorf("ttl = %v, want %v", got, want)
+	}
+}
+
+func TestStreamsDoNotWriteChannelPresence(t *testing.T) {
+	s, fake, _ := newTestServer(t, testConfig())
+	ts := startServer(t, s)
+
+	resp := openStream(t, ts, "/events?channel=room", "")
+	readEvent(t, bufio.NewReader(resp.Body))
+	resp.Body.Close()
+	waitFor(t, time.Second, func() bool { return s.TotalClients() == 0 })
+
+	if n := fake.countQueries("channels"); n != 0 {
+		t.Errorf("connecting and disconnecting ran %d channel statements: %v", n, fake.Queries())
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Roles a user can hold in channel_members.
+const (
+	memberOwner      = "owner"
+	memberPublisher  = "publisher"
+	memberSubscriber = "subscriber"
+)
+
+// channelRole returns userID's role in channel, or "" when they are not a
+// member. It reads the primary so a channel is publishable by its creator
+// straight after POST /channels.
+func (s *Server) channelRole(ctx context.Context, channel string, userID uint64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var role string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT role FROM channel_members WHERE channel = $1 AND user_id = $2", channel, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// requirePublisher lets a request to /channels/{name}/publish through only
+// when the caller is an owner or publisher of the channel.
+func (s *Server) requirePublisher(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireChannelRole("not_a_publisher", []string{memberOwner, memberPublisher}, next)
+}
+
+// requireOwner lets a request for channel {name} through only when the
+// caller owns the channel.
+func (s *Server) requireOwner(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireChannelRole("not_an_owner", []string{memberOwner}, next)
+}
+
+// requireChannelRole answers 403 with reason unless the caller holds one
+// of roles in the channel named in the path. Admins bypass the check.
+func (s *Server) requireChannelRole(reason string, roles []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.Role == roleAdmin {
+			next(w, r)
+			return
+		}
+
+		channel := r.PathValue("name")
+		role, err := s.channelRole(r.Context(), channel, claims.EffectiveUserID())
+		if err != nil {
+			s.logger.Error("Failed to look up channel role", "channel", channel, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !slices.Contains(roles, role) {
+			writeJSON(w, http.StatusForbidden, map[string]any{
+				"error":   "access_denied",
+				"reason":  reason,
+				"channel": channel,
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+const subscribersPageSize = 100
+
+type subscriberInfo struct {
+	UserID         uint64 `json:"user_id"`
+	Status         string `json:"status"`
+	ConnectedSince string `json:"connected_since,omitempty"`
+}
+
+// channelSubscribersHandler lists the users of channel {name}: members in
+// channel_members and anyone with a stream open to this instance. Users
+// are ordered by ID, subscribersPageSize at a time; next_cursor, when
+// present, is passed back as ?cursor= for the next page.
+func (s *Server) channelSubscribersHandler(w http.ResponseWriter, r *http.Request) {
+	channel := r.PathValue("name")
+
+	var cursor uint64
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "cursor must be a user id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Earliest open stream of every user currently connected to channel.
+	online := make(map[uint64]time.Time)
+	s.clients.View(func(clients []clientEntry) {
+		for _, c := range clients {
+			if c.meta.Channel != channel || c.meta.UserID == 0 {
+				continue
+			}
+			if t, ok := online[c.meta.UserID]; !ok || c.meta.ConnectedAt.Before(t) {
+				online[c.meta.UserID] = c.meta.ConnectedAt
+			}
+		}
+	})
+
+	registered, members, err := s.channelMemberPage(r.Context(), channel, cursor, subscribersPageSize+1)
+	if err != nil {
+		s.logger.Error("Failed to list channel members", "channel", channel, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	ids := members
+	for id := range online {
+		if id > cursor {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	resp := map[string]any{"live": len(online), "registered": registered}
+	if len(ids) > subscribersPageSize {
+		ids = ids[:subscribersPageSize]
+		resp["next_cursor"] = strconv.FormatUint(ids[len(ids)-1], 10)
+	}
+
+	users := make([]subscriberInfo, 0, len(ids))
+	for _, id := range ids {
+		info := subscriberInfo{UserID: id, Status: "offline"}
+		if t, ok := online[id]; ok {
+			info.Status = "online"
+			info.ConnectedSince = t.UTC().Format(time.RFC3339)
+		}
+		users = append(users, info)
+	}
+	resp["users"] = users
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// channelMemberPage returns how many members channel has and the IDs of
+// up to limit of them above after, in ascending order.
+func (s *Server) channelMemberPage(ctx context.Context, channel string, after uint64, limit int) (int, []uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var total int
+	if err := s.readDB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM channel_members WHERE channel = $1", channel).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := s.readDB.QueryContext(ctx, `
+		SELECT user_id FROM channel_members
+		WHERE channel = $1 AND user_id > $2
+		ORDER BY user_id
+		LIMIT $3`, channel, after, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+	return total, ids, rows.Err()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes a token even when none is left and returns how long the
+// caller must wait before acting on it. The debt is paid back by later
+// refills, so callers that honour the wait stay within rate.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket will have refilled to burst by now, so
+// dropping it and starting a new full one later changes nothing.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// newClientBucket returns the bucket limiting delivery to one SSE client,
+// or nil when MAX_EVENTS_PER_CLIENT_PER_SECOND is 0. A client may take one
+// second's worth of events at once.
+func (s *Server) newClientBucket() *tokenBucket {
+	if s.config.ClientRateLimit <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   s.config.ClientRateLimit,
+		burst:  s.config.ClientRateLimit,
+		tokens: s.config.ClientRateLimit,
+		last:   s.clock.Now(),
+	}
+}
+
+// channelLimit is the rate limit state of one channel.
+type channelLimit struct {
+	bucket tokenBucket
+	// backlog is non-zero while events this instance throttled may still
+	// be queued. Each throttleEvent bumps it, so clearBacklogs can tell
+	// whether one happened while it was checking the queue.
+	backlog atomic.Int64
+}
+
+// channelLimitFor returns channel's limit state, creating it with a full
+// bucket.
+func (s *Server) channelLimitFor(channel string) *channelLimit {
+	if v, ok := s.channelBuckets.Load(channel); ok {
+		return v.(*channelLimit)
+	}
+	burst := float64(max(s.config.ChannelBurst, 1))
+	l := &channelLimit{bucket: tokenBucket{
+		rate:   s.config.ChannelRateLimit,
+		burst:  burst,
+		tokens: burst,
+		last:   s.clock.Now(),
+	}}
+	v, _ := s.channelBuckets.LoadOrStore(channel, l)
+	return v.(*channelLimit)
+}
+
+// allowChannel takes a token from channel's bucket. Buckets are per
+// instance, so the effective limit grows with the number of instances.
+// A CHANNEL_RATE_LIMIT of 0 disables the limit.
+func (s *Server) allowChannel(channel string) bool {
+	if s.config.ChannelRateLimit <= 0 {
+		return true
+	}
+	return s.channelLimitFor(channel).bucket.Allow(s.clock.Now())
+}
+
+// channelBacklogged reports whether events this instance throttled for
+// channel may still be queued. New events then queue behind them rather
+// than overtake them once the bucket refills.
+func (s *Server) channelBacklogged(channel string) bool {
+	if s.config.ChannelRateLimit <= 0 {
+		return false
+	}
+	return s.channelLimitFor(channel).backlog.Load() != 0
+}
+
+// throttleEvent stores an event that exceeded its channel's rate limit
+// for the pending worker to deliver once the bucket refills, with msg and
+// stored as for enqueueEvent. It returns the event's position among the
+// channel's throttled events.
+func (s *Server) throttleEvent(ctx context.Context, eventID, channel, eventType string, msg, stored []byte) (int64, error) {
+	// Marked before the insert, so a clearBacklogs that runs meanwhile
+	// cannot miss it.
+	s.channelLimitFor(channel).backlog.Add(1)
+
+	var position int64
+	// The count does not see the row being inserted by the same
+	// statement, hence the + 1.
+	err := s.db.QueryRowContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO pending_events (event_id, channel, event_type, message, stored_message, status)
+			VALUES ($1, $2, $3, $4, $5, 'throttled')
+		)
+		SELECT COUNT(*) + 1 FROM pending_events WHERE channel = $2 AND status = 'throttled'`,
+		eventID, channel, eventType, msg, storedMessage(msg, stored)).Scan(&position)
+	return position, err
+}
+
+// evictIdleBuckets drops the channel and trigger rate limit state that
+// has gone back to its initial value. Channel names and user IDs come
+// from callers, so without this the maps would only ever grow.
+func (s *Server) evictIdleBuckets(now time.Time) {
+	s.channelBuckets.Range(func(k, v any) bool {
+		if l := v.(*channelLimit); l.backlog.Load() == 0 && l.bucket.idle(now) {
+			s.channelBuckets.CompareAndDelete(k, v)
+		}
+		return true
+	})
+	if l, ok := s.triggerLimiter.(idleEvicter); ok {
+		l.evictIdle(now)
+	}
+}
+
+// clearBacklogs ends the backlog of every channel whose throttled events
+// have all been delivered, whichever instance's worker delivered them.
+func (s *Server) clearBacklogs(ctx context.Context) error {
+	marks := make(map[string]int64)
+	s.channelBuckets.Range(func(k, v any) bool {
+		if n := v.(*channelLimit).backlog.Load(); n != 0 {
+			marks[k.(string)] = n
+		}
+		return true
+	})
+	if len(marks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT DISTINCT channel FROM pending_events WHERE status = 'throttled' AND channel = ANY($1)",
+		slices.Collect(maps.Keys(marks)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			return err
+		}
+		delete(marks, channel)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for channel, n := range marks {
+		s.channelLimitFor(channel).backlog.CompareAndSwap(n, 0)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pendingTable stands in for pending_events, answering the statements of
+// enqueueEvent, throttleEvent, processPendingBatch and clearBacklogs.
+type pendingTable struct {
+	mu   sync.Mutex
+	rows []pendingEvent
+	// deliverAt holds the deliver_at of scheduled rows.
+	deliverAt map[int64]time.Time
+	// onBacklogCheck, when set, runs as clearBacklogs queries the table.
+	onBacklogCheck func()
+}
+
+func (pt *pendingTable) handle(q fakeQuery) fakeResult {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	switch {
+	case strings.Contains(q.SQL, "INSERT INTO pending_events") && strings.Contains(q.SQL, "'throttled'"):
+		pt.insert(q, "throttled", nil)
+		return fakeRow(int64(pt.countThrottled(q.Args[1].(string))))
+	case strings.Contains(q.SQL, "INSERT INTO pending_events"):
+		deliverAt, _ := q.Args[5].(time.Time)
+		pt.insert(q, "pending", &deliverAt)
+		return fakeResult{RowsAffected: 1}
+	case strings.Contains(q.SQL, "SELECT id, status, event_id"):
+		res := fakeResult{}
+		for _, e := range pt.rows {
+			if (e.status == "throttled" || e.status == "pending") && !pt.deliverAt[e.id].After(time.Now()) {
+				res.Rows = append(res.Rows, []driver.Value{e.id, e.status, e.eventID, e.channel, e.eventType, e.message, e.stored})
+			}
+		}
+		return res
+	case strings.HasPrefix(q.SQL, "UPDATE pending_events SET status = $2"):
+		for i := range pt.rows {
+			if pt.rows[i].id == q.Args[0].(int64) {
+				pt.rows[i].status = q.Args[1].(string)
+			}
+		}
+		return fakeResult{RowsAffected: 1}
+	case strings.Contains(q.SQL, "SELECT DISTINCT channel FROM pending_events"):
+		if pt.onBacklogCheck != nil {
+			pt.onBacklogCheck()
+		}
+		res := fakeResult{Columns: []string{"channel"}}
+		for _, channel := range q.Args[0].([]string) {
+			if pt.countThrottled(channel) > 0 {
+				res.Rows = append(res.Rows, []driver.Value{channel})
+			}
+		}
+		return res
+	}
+	return unverifiedUsers(q)
+}
+
+// insert records a row from an INSERT taking event_id, channel,
+// event_type, message and stored_message as its first arguments.
+func (pt *pendingTable) insert(q fakeQuery, status string, deliverAt *time.Time) {
+	e := pendingEvent{
+		id:        int64(len(pt.rows) + 1),
+		status:    status,
+		eventID:   q.Args[0].(string),
+		channel:   q.Args[1].(string),
+		eventType: q.Args[2].(string),
+		message:   q.Args[3].([]byte),
+	}
+	e.stored, _ = q.Args[4].([]byte)
+	pt.rows = append(pt.rows, e)
+	if deliverAt != nil {
+		if pt.deliverAt == nil {
+			pt.deliverAt = make(map[int64]time.Time)
+		}
+		pt.deliverAt[e.id] = *deliverAt
+	}
+}
+
+func (pt *pendingTable) countThrottled(channel string) int {
+	n := 0
+	for _, e := range pt.rows {
+		if e.channel == channel && e.status == "throttled" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestThrottledEventsKeepOrder(t *testing.T) {
+	cfg := testConfig()
+	cfg.ChannelRateLimit = 1
+	cfg.ChannelBurst = 1
+	s, fake, clock := newTestServer(t, cfg)
+	table := &pendingTable{}
+	fake.setHandler(table.handle)
+	ch := addClients(s, 1, "room", 16)[0]
+	ctx := context.Background()
+
+	var sent []string
+	trigger := func(wantStatus string, wantPosition int64) {
+		t.Helper()
+		res, err := s.Triggers().Trigger(ctx, TriggerRequest{Channel: "room", EventType: "notification", Payload: []byte(`{}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != wantStatus || res.QueuePosition != wantPosition {
+			t.Fatalf("event %d: status %q position %d, want %q %d", len(sent)+1, res.Status, res.QueuePosition, wantStatus, wantPosition)
+		}
+		sent = append(sent, res.EventID)
+	}
+	work := func() {
+		t.Helper()
+		if err := s.processPendingBatch(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.clearBacklogs(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trigger(triggerStatusTriggered, 0)
+	trigger(triggerStatusThrottled, 1)
+	trigger(triggerStatusThrottled, 2)
+
+	// The bucket has refilled, but two events are still queued.
+	clock.Advance(time.Second)
+	trigger(triggerStatusThrottled, 3)
+
+	// Each batch has one token, for the oldest queued event.
+	for range 3 {
+		work()
+		clock.Advance(time.Second)
+	}
+	if n := table.countThrottled("room"); n != 0 {
+		t.Fatalf("%d events still throttled", n)
+	}
+
+	// With the queue empty, events go out directly again.
+	trigger(triggerStatusTriggered, 0)
+
+	var got []string
+	for len(ch) > 0 {
+		got = append(got, (<-ch).ID)
+	}
+	if !slices.Equal(got, sent) {
+		t.Errorf("delivered %v, want %v", got, sent)
+	}
+}
+
+func TestClearBacklogsKeepsConcurrentThrottle(t *testing.T) {
+	cfg := testConfig()
+	cfg.ChannelRateLimit = 1
+	s, fake, _ := newTestServer(t, cfg)
+	table := &pendingTable{}
+	fake.setHandler(table.handle)
+
+	s.channelLimitFor("room").backlog.Add(1)
+	// An event throttled while clearBacklogs looks at the queue must keep
+	// the channel backlogged, even though the query did not see it.
+	table.onBacklogCheck = func() { s.channelLimitFor("room").backlog.Add(1) }
+	if err := s.clearBacklogs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !s.channelBacklogged("room") {
+		t.Error("backlog cleared despite a throttle during the check")
+	}
+
+	table.onBacklogCheck = nil
+	if err := s.clearBacklogs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.channelBacklogged("room") {
+		t.Error("backlog not cleared with the queue empty")
+	}
+}
+
+func TestEvictIdleBuckets(t *testing.T) {
+	tests := []struct {
+		name     string
+		take     int
+		advance  time.Duration
+		backlog  bool
+		wantKept bool
+	}{
+		{name: "untouched", take: 0},
+		{name: "drained", take: 2, wantKept: true},
+		{name: "partly refilled", take: 2, advance: time.Second, wantKept: true},
+		{name: "refilled", take: 2, advance: 2 * time.Second},
+		{name: "refilled with backlog", take: 2, advance: 2 * time.Second, backlog: true, wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ChannelRateLimit = 1
+			cfg.ChannelBurst = 2
+			s, _, clock := newTestServer(t, cfg)
+
+			l := s.channelLimitFor("room")
+			for range tt.take {
+				s.allowChannel("room")
+			}
+			if tt.backlog {
+				l.backlog.Add(1)
+			}
+			clock.Advance(tt.advance)
+
+			s.evictIdleBuckets(clock.Now())
+			_, kept := s.channelBuckets.Load("room")
+			if kept != tt.wantKept {
+				t.Errorf("bucket kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestTriggerLimitersEvictIdleKeys(t *testing.T) {
+	keys := func(l rateLimiter) int {
+		switch l := l.(type) {
+		case *windowLimiter:
+			return len(l.entries)
+		case *slidingLimiter:
+			return len(l.entries)
+		case *bucketLimiter:
+			n := 0
+			l.buckets.Range(func(any, any) bool { n++; return true })
+			return n
+		}
+		panic("unknown limiter")
+	}
+
+	tests := []struct {
+		name    string
+		limiter rateLimiter
+	}{
+		{name: "fixed window", limiter: &windowLimiter{limit: 2, window: time.Minute}},
+		{name: "sliding window", limiter: &slidingLimiter{limit: 2, window: time.Minute}},
+		{name: "token bucket", limiter: &bucketLimiter{limit: 2, window: time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			// Each key uses up its limit, so nothing is idle before the
+			// whole window has passed.
+			for range 2 {
+				tt.limiter.Allow(context.Background(), "1", now)
+				tt.limiter.Allow(context.Background(), "2", now.Add(30*time.Second))
+			}
+
+			evicter := tt.limiter.(idleEvicter)
+			evicter.evictIdle(now.Add(30 * time.Second))
+			if n := keys(tt.limiter); n != 2 {
+				t.Fatalf("%d keys after evicting within the window, want 2", n)
+			}
+			evicter.evictIdle(now.Add(time.Minute))
+			if n := keys(tt.limiter); n != 1 {
+				t.Fatalf("%d keys after the first key's window, want 1", n)
+			}
+			evicter.evictIdle(now.Add(90 * time.Second))
+			if n := keys(tt.limiter); n != 0 {
+				t.Fatalf("%d keys after both windows, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"slices"
+	"sync"
+)
+
+// ChannelInfo is one row of the channels table as the registry holds it.
+type ChannelInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+	Static      bool   `json:"static"`
+	// RetentionDays is nil when the channel uses RETENTION_DAYS.
+	RetentionDays *int `json:"retention_days,omitempty"`
+}
+
+// ChannelRegistry keeps the channels table in memory. Channels created or
+// deleted by this instance are applied straight away; changes made by
+// migrations or other services show up on the next Refresh.
+type ChannelRegistry struct {
+	db *sql.DB
+
+	mu       sync.RWMutex
+	channels map[string]ChannelInfo
+	// listing caches the GET /channels body and its ETag until the
+	// channels change.
+	listing *channelListing
+}
+
+type channelListing struct {
+	body []byte
+	etag string
+}
+
+// Refresh replaces the registry's contents with the channels table. On
+// error the previous contents are kept.
+func (c *ChannelRegistry) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx,
+		"SELECT name, description, visibility, is_static, retention_days FROM channels")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	channels := make(map[string]ChannelInfo)
+	for rows.Next() {
+		var (
+			info      ChannelInfo
+			retention sql.NullInt32
+		)
+		if err := rows.Scan(&info.Name, &info.Description, &info.Visibility, &info.Static, &retention); err != nil {
+			return err
+		}
+		if retention.Valid {
+			days := int(retention.Int32)
+			info.RetentionDays = &days
+		}
+		channels[info.Name] = info
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.channels = channels
+	c.listing = nil
+	c.mu.Unlock()
+	return nil
+}
+
+// Get returns the channel called name, if the registry knows it.
+func (c *ChannelRegistry) Get(name string) (ChannelInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, ok := c.channels[name]
+	return info, ok
+}
+
+// List returns every known channel ordered by name.
+func (c *ChannelRegistry) List() []ChannelInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sortedLocked()
+}
+
+// sortedLocked returns the channels ordered by name. c.mu must be held.
+func (c *ChannelRegistry) sortedLocked() []ChannelInfo {
+	list := make([]ChannelInfo, 0, len(c.channels))
+	for _, info := range c.channels {
+		list = append(list, info)
+	}
+	slices.SortFunc(list, func(a, b ChannelInfo) int { return cmp.Compare(a.Name, b.Name) })
+	return list
+}
+
+func (c *ChannelRegistry) add(info ChannelInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.channels == nil {
+		c.channels = make(map[string]ChannelInfo)
+	}
+	c.channels[info.Name] = info
+	c.listing = nil
+}
+
+func (c *ChannelRegistry) remove(name string) {
+	c.mu.Lock()
+	delete(c.channels, name)
+	c.listing = nil
+	c.mu.Unlock()
+}
+
+// Listing returns the JSON body of GET /channels and its ETag, the MD5 of
+// that body. Both are computed once per change to the registry.
+func (c *ChannelRegistry) Listing() ([]byte, string) {
+	c.mu.RLock()
+	l := c.listing
+	c.mu.RUnlock()
+	if l != nil {
+		return l.body, l.etag
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listing == nil {
+		body, _ := jsonMarshal(map[string]any{"channels": c.sortedLocked()})
+		body = append(body, '\n')
+		sum := md5.Sum(body)
+		c.listing = &channelListing{body: body, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+	}
+	return c.listing.body, c.listing.etag
+}
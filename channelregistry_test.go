@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// channelsTable stands in for the channels table as Refresh reads it.
+type channelsTable struct {
+	mu   sync.Mutex
+	rows [][]driver.Value
+	err  error
+}
+
+func (ct *channelsTable) set(rows [][]driver.Value, err error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.rows, ct.err = rows, err
+}
+
+func (ct *channelsTable) handle(q fakeQuery) fakeResult {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if strings.HasPrefix(q.SQL, "SELECT name, description, visibility, is_static, retention_days FROM channels") {
+		return fakeResult{Columns: []string{"name", "description", "visibility", "is_static", "retention_days"}, Rows: ct.rows, Err: ct.err}
+	}
+	return unverifiedUsers(q)
+}
+
+func TestChannelRegistryRefresh(t *testing.T) {
+	news := []driver.Value{"news", "Headlines", visibilityPublic, true, nil}
+	alerts := []driver.Value{"alerts", "", visibilityPublic, false, int64(7)}
+
+	tests := []struct {
+		name string
+		rows [][]driver.Value
+		err  error
+		want []string
+		// wantChanged says whether the GET /channels ETag changes.
+		wantChanged bool
+	}{
+		{name: "startup", rows: [][]driver.Value{news}, want: []string{"news"}, wantChanged: true},
+		{name: "channel added", rows: [][]driver.Value{news, alerts}, want: []string{"alerts", "news"}, wantChanged: true},
+		{name: "unchanged", rows: [][]driver.Value{alerts, news}, want: []string{"alerts", "news"}},
+		{name: "query fails", err: errors.New("connection refused"), want: []string{"alerts", "news"}},
+		{name: "channel removed", rows: [][]driver.Value{alerts}, want: []string{"alerts"}, wantChanged: true},
+	}
+
+	s, fake, _ := newTestServer(t, testConfig())
+	table := &channelsTable{}
+	fake.setHandler(table.handle)
+	_, etag := s.channels.Listing()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table.set(tt.rows, tt.err)
+			err := s.channels.Refresh(context.Background())
+			if (err != nil) != (tt.err != nil) {
+				t.Fatalf("Refresh = %v, want error %v", err, tt.err != nil)
+			}
+
+			var got []string
+			for _, info := range s.channels.List() {
+				got = append(got, info.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("channels = %v, want %v", got, tt.want)
+			}
+
+			_, next := s.channels.Listing()
+			if changed := next != etag; changed != tt.wantChanged {
+				t.Errorf("ETag changed = %v, want %v", changed, tt.wantChanged)
+			}
+			etag = next
+		})
+	}
+
+	info, ok := s.channels.Get("alerts")
+	if !ok || info.Static || info.RetentionDays == nil || *info.RetentionDays != 7 {
+		t.Errorf("alerts = %+v, %v", info, ok)
+	}
+}
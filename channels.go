@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const maxChannelNameLength = 64
+
+var (
+	defaultChannelNamePattern   = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	defaultReservedChannelNames = []string{"admin", "system", "__all__"}
+)
+
+// channelNameError describes why a channel name was rejected.
+type channelNameError struct {
+	Reason string
+	Detail string
+}
+
+func (e *channelNameError) Error() string { return e.Detail }
+
+func (s *Server) validateChannelName(name string) *channelNameError {
+	switch {
+	case name == "":
+		return &channelNameError{Reason: "empty", Detail: "channel name must not be empty"}
+	case len(name) > maxChannelNameLength:
+		return &channelNameError{Reason: "too_long", Detail: fmt.Sprintf("channel name must be at most %d characters", maxChannelNameLength)}
+	case !s.config.ChannelNamePattern.MatchString(name):
+		return &channelNameError{Reason: "invalid_format", Detail: "channel name must match " + s.config.ChannelNamePattern.String()}
+	case slices.Contains(s.config.ReservedChannelNames, name):
+		return &channelNameError{Reason: "reserved", Detail: fmt.Sprintf("channel name %q is reserved", name)}
+	}
+	return nil
+}
+
+// channelsHandler serves /channels: GET lists channels, POST creates one.
+func (s *Server) channelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.listChannelsHandler(w, r)
+		return
+	}
+	s.createChannelHandler(w, r)
+}
+
+// listChannelsHandler returns the channels from the in-memory registry.
+// Clients that poll it send the last ETag in If-None-Match and get 304
+// until a channel is created or deleted.
+func (s *Server) listChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	body, etag := s.channels.Listing()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "max-age=60, must-revalidate")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// validators match too, as RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type createChannelRequest struct {
+	Name string `json:"name"`
+	// RetentionDays overrides RETENTION_DAYS for the channel's events; nil
+	// keeps the global setting and 0 keeps them forever.
+	RetentionDays *int `json:"retention_days"`
+}
+
+func (s *Server) createChannelHandler(w http.ResponseWriter, r *http.Request) {
+	var req createChannelRequest
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, s.config.MaxPayloadBytes), &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if verr := s.validateChannelName(req.Name); verr != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "invalid_channel_name",
+			"reason": verr.Reason,
+			"detail": verr.Detail,
+		})
+		return
+	}
+	if req.RetentionDays != nil && (*req.RetentionDays < 0 || *req.RetentionDays > maxRetentionDays) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "invalid_retention_days",
+			"detail": fmt.Sprintf("retention_days must be an integer from 0 to %d", maxRetentionDays),
+		})
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	createdAt, err := s.createChannel(r.Context(), req.Name, claims.EffectiveUserID(), claims.Role == roleAdmin, req.RetentionDays)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			http.Error(w, "Channel already exists", http.StatusConflict)
+			return
+		}
+		s.logger.Error("Failed to create channel", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]any{
+		"name":       req.Name,
+		"created_at": createdAt.UTC().Format(time.RFC3339),
+	}
+	if req.RetentionDays != nil {
+		resp["retention_days"] = *req.RetentionDays
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// createChannel inserts the channel and makes its creator the owner in
+// one transaction. Channels created by admins are static and never
+// cleaned up; others start with a lease of ChannelIdleTTL, so one nobody
+// ever subscribes to is still deleted. A nil retentionDays leaves the
+// channel on RETENTION_DAYS.
+func (s *Server) createChannel(ctx context.Context, name string, creator uint64, static bool, retentionDays *int) (time.Time, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	// A NULL interval leaves cleanup_at NULL.
+	var leaseSecs any
+	if !static {
+		leaseSecs = s.config.ChannelIdleTTL.Seconds()
+	}
+	var createdAt time.Time
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO channels (name, created_by, is_static, retention_days, cleanup_at)
+		VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5)) RETURNING created_at`,
+		name, creator, static, retentionDays, leaseSecs).Scan(&createdAt); err != nil {
+		return time.Time{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO channel_members (channel, user_id, role) VALUES ($1, $2, $3)",
+		name, creator, memberOwner); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	s.channels.add(ChannelInfo{Name: name, Visibility: visibilityPublic, Static: static, RetentionDays: retentionDays})
+	return createdAt, nil
+}
+
+// Values of channels.visibility.
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+)
+
+const (
+	maxChannelDescriptionLength = 1024
+	maxRetentionDays            = 36500
+)
+
+// updateChannelRequest is the body of PATCH /channels/{name}. Absent
+// fields are left unchanged. retention_days may be null to put the
+// channel back on RETENTION_DAYS, so it is kept raw until applied.
+type updateChannelRequest struct {
+	Description   *string         `json:"description"`
+	Visibility    *string         `json:"visibility"`
+	RetentionDays json.RawMessage `json:"retention_days"`
+}
+
+// updateChannelHandler changes a channel's description, visibility and
+// retention. The row is read, patched and written back only if nobody
+// updated it in between; a request that loses that race gets 409 and
+// can simply be retried.
+func (s *Server) updateChannelHandler(w http.ResponseWriter, r *http.Request) {
+	var req updateChannelRequest
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, s.config.MaxPayloadBytes), &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	name := r.PathValue("name")
+	ch, updatedAt, err := s.loadChannel(r.Context(), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load channel", "channel", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if field, detail := applyChannelUpdate(&ch, req); field != "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "invalid_field",
+			"field":  field,
+			"detail": detail,
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dbQueryTimeout)
+	defer cancel()
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE channels SET description = $1, visibility = $2, retention_days = $3, updated_at = NOW()
+		WHERE name = $4 AND updated_at = $5
+		RETURNING updated_at`,
+		ch.Description, ch.Visibility, ch.RetentionDays, name, updatedAt).Scan(&updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":  "concurrent_update",
+			"detail": "the channel changed while this request was processed; retry it",
+		})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to update channel", "channel", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.channels.add(ch)
+	s.logger.Info("Channel updated", "channel", name)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":           ch.Name,
+		"description":    ch.Description,
+		"visibility":     ch.Visibility,
+		"static":         ch.Static,
+		"retention_days": ch.RetentionDays,
+		"updated_at":     updatedAt.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// loadChannel reads a channel and the updated_at an update must match.
+func (s *Server) loadChannel(ctx context.Context, name string) (ChannelInfo, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var (
+		ch        ChannelInfo
+		retention sql.NullInt32
+		updatedAt time.Time
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT name, description, visibility, is_static, retention_days, updated_at
+		FROM channels WHERE name = $1`, name).
+		Scan(&ch.Name, &ch.Description, &ch.Visibility, &ch.Static, &retention, &updatedAt)
+	if retention.Valid {
+		days := int(retention.Int32)
+		ch.RetentionDays = &days
+	}
+	return ch, updatedAt, err
+}
+
+// applyChannelUpdate copies the fields present in req onto ch. It returns
+// the first invalid field and why, or "" when all are valid.
+func applyChannelUpdate(ch *ChannelInfo, req updateChannelRequest) (field, detail string) {
+	if req.Description != nil {
+		if len(*req.Description) > maxChannelDescriptionLength {
+			return "description", fmt.Sprintf("must be at most %d bytes", maxChannelDescriptionLength)
+		}
+		ch.Description = *req.Description
+	}
+
+	if req.Visibility != nil {
+		switch *req.Visibility {
+		case visibilityPublic, visibilityPrivate:
+			ch.Visibility = *req.Visibility
+		default:
+			return "visibility", `must be "public" or "private"`
+		}
+	}
+
+	if req.RetentionDays != nil {
+		if string(req.RetentionDays) == "null" {
+			ch.RetentionDays = nil
+			return "", ""
+		}
+		var days int
+		if err := jsonUnmarshal(req.RetentionDays, &days); err != nil || days < 0 || days > maxRetentionDays {
+			return "retention_days", fmt.Sprintf("must be null or an integer from 0 to %d", maxRetentionDays)
+		}
+		ch.RetentionDays = &days
+	}
+	return "", ""
+}
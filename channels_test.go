@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListChannelsETag(t *testing.T) {
+	s, fake, _ := newTestServer(t, testConfig())
+	fake.setHandler(func(q fakeQuery) fakeResult {
+		if strings.HasPrefix(q.SQL, "INSERT INTO channels") {
+			return fakeRow(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		}
+		return unverifiedUsers(q)
+	})
+	s.channels.add(ChannelInfo{Name: "news", Visibility: visibilityPublic})
+
+	list := func(ifNoneMatch string) (int, string, string) {
+		t.Helper()
+		r := authRequest(t, http.MethodGet, "/channels", 1, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := serve(s, r)
+		if got := w.Header().Get("Cache-Control"); got != "max-age=60, must-revalidate" {
+			t.Errorf("Cache-Control = %q", got)
+		}
+		return w.Code, w.Header().Get("ETag"), w.Body.String()
+	}
+
+	code, etag, body := list("")
+	if code != http.StatusOK || etag == "" || !strings.Contains(body, `"name":"news"`) {
+		t.Fatalf("first GET = %d, ETag %q, body %s", code, etag, body)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{name: "matching", ifNoneMatch: etag, wantStatus: http.StatusNotModified},
+		{name: "weak", ifNoneMatch: "W/" + etag, wantStatus: http.StatusNotModified},
+		{name: "in a list", ifNoneMatch: `"other", ` + etag, wantStatus: http.StatusNotModified},
+		{name: "wildcard", ifNoneMatch: "*", wantStatus: http.StatusNotModified},
+		{name: "stale", ifNoneMatch: `"other"`, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, got, body := list(tt.ifNoneMatch)
+			if code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", code, tt.wantStatus)
+			}
+			if got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if code == http.StatusNotModified && body != "" {
+				t.Errorf("304 has a body: %s", body)
+			}
+		})
+	}
+
+	w := serve(s, authRequest(t, http.MethodPost, "/channels", 1, strings.NewReader(`{"name":"alerts"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+	}
+	code, created, body := list(etag)
+	if code != http.StatusOK || created == etag || !strings.Contains(body, `"name":"alerts"`) {
+		t.Errorf("GET after create = %d, ETag %q (was %q), body %s", code, created, etag, body)
+	}
+
+	s.forgetChannel("alerts")
+	if code, deleted, _ := list(created); code != http.StatusOK || deleted != etag {
+		t.Errorf("GET after delete = %d, ETag %q, want 200 and the original %q", code, deleted, etag)
+	}
+}
+
+func TestCreateChannelRetention(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "default", body: `{"name":"audit"}`, wantStatus: http.StatusCreated},
+		{name: "zero", body: `{"name":"audit","retention_days":0}`, wantStatus: http.StatusCreated},
+		{name: "longest", body: `{"name":"audit","retention_days":36500}`, wantStatus: http.StatusCreated},
+		{name: "negative", body: `{"name":"audit","retention_days":-1}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "too long", body: `{"name":"audit","retention_days":36501}`, wantStatus: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, _ := newTestServer(t, testConfig())
+			fake.setHandler(func(q fakeQuery) fakeResult {
+				if strings.HasPrefix(q.SQL, "INSERT INTO channels") {
+					return fakeRow(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+				}
+				return unverifiedUsers(q)
+			})
+
+			w := serve(s, authRequest(t, http.MethodPost, "/channels", 1, strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			_, created := s.channels.Get("audit")
+			if want := tt.wantStatus == http.StatusCreated; created != want {
+				t.Errorf("channel registered = %v, want %v", created, want)
+			}
+			if tt.wantStatus == http.StatusUnprocessableEntity && !strings.Contains(w.Body.String(), `"invalid_retention_days"`) {
+				t.Errorf("body %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateChannel(t *testing.T) {
+	loaded := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
+	saved := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		role       string // the caller's role in the channel, if any
+		admin      bool
+		missing    bool
+		conflict   bool
+		body       string
+		wantStatus int
+		wantBody   []string
+		wantUpdate bool
+	}{
+		{name: "owner", role: memberOwner, body: `{"description":"Breaking news","retention_days":14}`,
+			wantStatus: http.StatusOK, wantUpdate: true,
+			wantBody: []string{`"description":"Breaking news"`, `"retention_days":14`, `"visibility":"public"`, `"updated_at":"2024-01-01T12:00:00Z"`}},
+		{name: "admin", admin: true, body: `{"visibility":"private"}`,
+			wantStatus: http.StatusOK, wantUpdate: true,
+			wantBody: []string{`"description":"old"`, `"visibility":"private"`}},
+		{name: "member", role: memberSubscriber, body: `{"description":"x"}`,
+			wantStatus: http.StatusForbidden, wantBody: []string{`"not_an_owner"`}},
+		{name: "unknown channel", role: memberOwner, missing: true, body: `{"description":"x"}`,
+			wantStatus: http.StatusNotFound},
+		{name: "invalid field", role: memberOwner, body: `{"visibility":"secret"}`,
+			wantStatus: http.StatusUnprocessableEntity, wantBody: []string{`"field":"visibility"`}},
+		{name: "invalid JSON", role: memberOwner, body: `{"description":`,
+			wantStatus: http.StatusBadRequest},
+		{name: "concurrent update", role: memberOwner, conflict: true, body: `{"description":"x"}`,
+			wantStatus: http.StatusConflict, wantUpdate: true, wantBody: []string{`"concurrent_update"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, _ := newTestServer(t, testConfig())
+			s.channels.add(ChannelInfo{Name: "news", Description: "old", Visibility: visibilityPublic})
+			fake.setHandler(func(q fakeQuery) fakeResult {
+				switch {
+				case strings.HasPrefix(q.SQL, "SELECT role FROM channel_members"):
+					if tt.role == "" {
+						return fakeResult{}
+					}
+					return fakeRow(tt.role)
+				case strings.Contains(q.SQL, "FROM channels WHERE name = $1"):
+					if tt.missing {
+						return fakeResult{}
+					}
+					return fakeRow("news", "old", visibilityPublic, false, nil, loaded)
+				case strings.Contains(q.SQL, "UPDATE channels SET description"):
+					if tt.conflict {
+						return fakeResult{}
+					}
+					return fakeRow(saved)
+				}
+				return unverifiedUsers(q)
+			})
+
+			r := authRequest(t, http.MethodPatch, "/channels/news", 1, strings.NewReader(tt.body))
+			if tt.admin {
+				r.Header.Set("Authorization", "Bearer "+signToken(t, &Claims{UserID: 1, Role: roleAdmin}))
+			}
+			w := serve(s, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body %s does not contain %s", w.Body, want)
+				}
+			}
+
+			var updates []fakeQuery
+			for _, q := range fake.Queries() {
+				if strings.Contains(q.SQL, "UPDATE channels") {
+					updates = append(updates, q)
+				}
+			}
+			if !tt.wantUpdate {
+				if len(updates) != 0 {
+					t.Errorf("ran %d UPDATEs, want none", len(updates))
+				}
+				return
+			}
+			if len(updates) != 1 {
+				t.Fatalf("ran %d UPDATEs, want 1", len(updates))
+			}
+			// The write only succeeds if the row is still the one read.
+			if got := updates[0].Args[4]; got != driver.Value(loaded) {
+				t.Errorf("UPDATE matched updated_at %v, want %v", got, loaded)
+			}
+
+			info, _ := s.channels.Get("news")
+			if tt.conflict && info.Description != "old" {
+				t.Errorf("registry description = %q after a lost update", info.Description)
+			}
+			if !tt.conflict && !strings.Contains(w.Body.String(), `"description":"`+info.Description+`"`) {
+				t.Errorf("registry holds %+v, response %s", info, w.Body)
+			}
+		})
+	}
+}
+
+func TestApplyChannelUpdate(t *testing.T) {
+	seven := 7
+	tests := []struct {
+		name      string
+		body      string
+		want      ChannelInfo
+		wantField string
+	}{
+		{name: "empty", body: `{}`,
+			want: ChannelInfo{Description: "old", Visibility: visibilityPublic, RetentionDays: &seven}},
+		{name: "all fields", body: `{"description":"new","visibility":"private","retention_days":14}`,
+			want: ChannelInfo{Description: "new", Visibility: visibilityPrivate, RetentionDays: intPtr(14)}},
+		{name: "clear description", body: `{"description":""}`,
+			want: ChannelInfo{Visibility: visibilityPublic, RetentionDays: &seven}},
+		{name: "default retention", body: `{"retention_days":null}`,
+			want: ChannelInfo{Description: "old", Visibility: visibilityPublic}},
+		{name: "zero retention", body: `{"retention_days":0}`,
+			want: ChannelInfo{Description: "old", Visibility: visibilityPublic, RetentionDays: intPtr(0)}},
+		{name: "description too long", body: `{"description":"` + strings.Repeat("x", maxChannelDescriptionLength+1) + `"}`,
+			wantField: "description"},
+		{name: "unknown visibility", body: `{"visibility":"hidden"}`, wantField: "visibility"},
+		{name: "negative retention", body: `{"retention_days":-1}`, wantField: "retention_days"},
+		{name: "retention too long", body: `{"retention_days":36501}`, wantField: "retention_days"},
+		{name: "fractional retention", body: `{"retention_days":1.5}`, wantField: "retention_days"},
+		{name: "retention as string", body: `{"retention_days":"14"}`, wantField: "retention_days"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req updateChannelRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			ch := ChannelInfo{Description: "old", Visibility: visibilityPublic, RetentionDays: &seven}
+			field, detail := applyChannelUpdate(&ch, req)
+			if field != tt.wantField {
+				t.Fatalf("invalid field = %q (%s), want %q", field, detail, tt.wantField)
+			}
+			if field != "" {
+				return
+			}
+			if !reflect.DeepEqual(ch, tt.want) {
+				t.Errorf("channel = %+v, want %+v", ch, tt.want)
+			}
+		})
+	}
+}
+
+func intPtr(n int) *int { return &n }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBucketsMs are the upper bounds, in milliseconds, of the buckets
+// channelStats sorts fan-out durations into. Anything slower lands in a
+// final overflow bucket.
+var latencyBucketsMs = [...]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// channelStats are the in-memory delivery counters of one channel since
+// startup. Every field is updated atomically, so broadcasts never lock.
+type channelStats struct {
+	broadcast atomic.Int64
+	dropped   atomic.Int64
+	latency   [len(latencyBucketsMs) + 1]atomic.Int64
+}
+
+// observeLatency records how long one broadcast took to fan out.
+func (c *channelStats) observeLatency(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	i := 0
+	for i < len(latencyBucketsMs) && ms > latencyBucketsMs[i] {
+		i++
+	}
+	c.latency[i].Add(1)
+}
+
+// latencyQuantile estimates the q-th quantile of the recorded latencies
+// in milliseconds by interpolating inside the bucket it falls in, the
+// way Prometheus' histogram_quantile does. It returns 0 with no samples.
+func (c *channelStats) latencyQuantile(q float64) float64 {
+	var counts [len(latencyBucketsMs) + 1]int64
+	var total int64
+	for i := range c.latency {
+		counts[i] = c.latency[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var seen int64
+	for i, n := range counts {
+		if float64(seen+n) < rank || n == 0 {
+			seen += n
+			continue
+		}
+		if i == len(latencyBucketsMs) {
+			// The overflow bucket has no upper bound to interpolate to.
+			return latencyBucketsMs[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = latencyBucketsMs[i-1]
+		}
+		return lower + (latencyBucketsMs[i]-lower)*(rank-float64(seen))/float64(n)
+	}
+	return latencyBucketsMs[len(latencyBucketsMs)-1]
+}
+
+// channelStatsFor returns the stats of channel, creating them on first
+// use.
+func (s *Server) channelStatsFor(channel string) *channelStats {
+	if v, ok := s.channelStats.Load(channel); ok {
+		return v.(*channelStats)
+	}
+	v, _ := s.channelStats.LoadOrStore(channel, new(channelStats))
+	return v.(*channelStats)
+}
+
+// adminChannelMetricsHandler reports the delivery statistics of the
+// channel in the path as seen by this instance. Latencies are how long
+// fan-out to local subscribers took, not time to the client.
+func (s *Server) adminChannelMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	channel := r.PathValue("name")
+	// Looking a channel up must not make it appear in /admin/stats.
+	stats := new(channelStats)
+	if v, ok := s.channelStats.Load(channel); ok {
+		stats = v.(*channelStats)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channel":             channel,
+		"total_broadcast":     stats.broadcast.Load(),
+		"total_dropped":       stats.dropped.Load(),
+		"p50_delivery_ms":     stats.latencyQuantile(0.5),
+		"p99_delivery_ms":     stats.latencyQuantile(0.99),
+		"current_subscribers": s.SubscriberCount(channel),
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	AppEnv            string
+	Port              string
+	JwtSecret         []byte
+	DatabaseURL       string
+	ReadReplicaURL    string
+	PGNotifyChannel   string
+	NginxSSEProxyMode bool
+	BehindProxy       bool
+	SSERetry          time.Duration
+	HeartbeatInterval time.Duration
+	MaxSSEConnections int
+	SSEOverloadRetry  time.Duration
+	TokenTTL          time.Duration
+	AuthCacheTTL      time.Duration
+
+	ShutdownSSETimeout time.Duration
+	ShutdownAPITimeout time.Duration
+
+	MigrationLockTimeout time.Duration
+
+	BackpressureStrategy BackpressureStrategy
+	BackpressureTimeout  time.Duration
+	BroadcastSendTimeout time.Duration
+
+	UseSyncMap      bool
+	EnableDebugUI   bool
+	EnableTCPTuning bool
+	EnableSimulate  bool
+
+	SSEClientsPerWorker int
+
+	MaxPayloadBytes         int64
+	TriggerRateLimit        int
+	TriggerRateWindow       time.Duration
+	RateLimitAlgorithm      string
+	RedisURL                string
+	ChannelRateLimit        float64
+	ChannelBurst            int
+	ClientRateLimit         float64
+	DedupWindow             time.Duration
+	ScheduleMaxAdvance      time.Duration
+	AsyncMaxRetries         int
+	RetentionDays           int
+	EventTypeReloadInterval time.Duration
+	RoutingReloadInterval   time.Duration
+	ChannelReloadInterval   time.Duration
+	DBStatsInterval         time.Duration
+
+	ChannelIdleTTL       time.Duration
+	ChannelNamePattern   *regexp.Regexp
+	ReservedChannelNames []string
+
+	ContentSecurityPolicy string
+	CORSAllowedOrigins    []string
+
+	StripFields []string
+}
+
+// loadConfig reads the configuration through getenv, which main passes
+// as the package's getenv once secret:// references are resolved and
+// tests replace with a fake environment. Required variables that are
+// missing are reported together in the returned error. Optional
+// variables with invalid values are logged and fall back to their
+// defaults.
+func loadConfig(getenv func(string) string) (Config, error) {
+	var errs []error
+
+	port := getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	secret := getenv("JWT_SECRET")
+	if secret == "" {
+		errs = append(errs, errors.New("JWT_SECRET is not set"))
+	}
+
+	dbURL := getenv("DATABASE_URL")
+	if dbURL == "" {
+		errs = append(errs, errors.New("DATABASE_URL is not set"))
+	}
+
+	nginxMode := getenv("NGINX_SSE_PROXY_MODE") == "true"
+
+	cfg := Config{
+		AppEnv:            getEnv(getenv, "APP_ENV", appEnvProduction),
+		Port:              port,
+		JwtSecret:         []byte(secret),
+		DatabaseURL:       dbURL,
+		ReadReplicaURL:    getenv("DB_READ_REPLICA_URL"),
+		PGNotifyChannel:   getenv("PG_NOTIFY_CHANNEL"),
+		NginxSSEProxyMode: nginxMode,
+		BehindProxy:       getenv("BEHIND_PROXY") == "true",
+		SSERetry:          time.Duration(getEnvInt(getenv, "SSE_RETRY_MS", 3000)) * time.Millisecond,
+		HeartbeatInterval: getEnvDuration(getenv, "HEARTBEAT_INTERVAL", 15*time.Second),
+		MaxSSEConnections: getEnvInt(getenv, "MAX_SSE_CONNECTIONS", 0),
+		SSEOverloadRetry:  getEnvDuration(getenv, "SSE_OVERLOAD_RETRY_AFTER", 30*time.Second),
+		TokenTTL:          getEnvDuration(getenv, "TOKEN_TTL", time.Hour),
+		AuthCacheTTL:      getEnvDuration(getenv, "AUTH_CACHE_TTL", 30*time.Second),
+
+		ShutdownSSETimeout: getEnvDuration(getenv, "SHUTDOWN_SSE_TIMEOUT", 30*time.Second),
+		ShutdownAPITimeout: getEnvDuration(getenv, "SHUTDOWN_API_TIMEOUT", 5*time.Second),
+
+		MigrationLockTimeout: getEnvDuration(getenv, "MIGRATION_LOCK_TIMEOUT", 5*time.Minute),
+
+		BackpressureStrategy: getEnvBackpressure(getenv, "BACKPRESSURE_STRATEGY", BackpressureDrop),
+		BackpressureTimeout:  time.Duration(getEnvInt(getenv, "BACKPRESSURE_TIMEOUT", 100)) * time.Millisecond,
+		BroadcastSendTimeout: time.Duration(getEnvInt(getenv, "BROADCAST_SEND_TIMEOUT_MS", 0)) * time.Millisecond,
+
+		UseSyncMap:      getenv("USE_SYNC_MAP") == "true",
+		EnableDebugUI:   getenv("ENABLE_DEBUG_UI") == "true",
+		EnableTCPTuning: getenv("ENABLE_TCP_TUNING") == "true",
+		EnableSimulate:  getenv("ENABLE_SIMULATE") == "true",
+
+		SSEClientsPerWorker: getEnvInt(getenv, "SSE_CLIENTS_PER_WORKER", 1000),
+
+		MaxPayloadBytes:         int64(getEnvInt(getenv, "MAX_PAYLOAD_BYTES", 64<<10)),
+		TriggerRateLimit:        getEnvInt(getenv, "TRIGGER_RATE_LIMIT", 0),
+		TriggerRateWindow:       getEnvDuration(getenv, "TRIGGER_RATE_WINDOW", time.Minute),
+		RateLimitAlgorithm:      getEnvRateLimitAlgorithm(getenv, "RATE_LIMIT_ALGORITHM", rateLimitFixedWindow),
+		RedisURL:                getenv("REDIS_URL"),
+		ChannelRateLimit:        float64(getEnvInt(getenv, "CHANNEL_RATE_LIMIT", 100)),
+		ChannelBurst:            getEnvInt(getenv, "CHANNEL_BURST", 200),
+		ClientRateLimit:         float64(getEnvInt(getenv, "MAX_EVENTS_PER_CLIENT_PER_SECOND", 50)),
+		DedupWindow:             time.Duration(getEnvInt(getenv, "DEDUP_WINDOW_MS", 0)) * time.Millisecond,
+		ScheduleMaxAdvance:      getEnvDuration(getenv, "SCHEDULE_MAX_ADVANCE", 30*24*time.Hour),
+		AsyncMaxRetries:         getEnvInt(getenv, "ASYNC_MAX_RETRIES", 3),
+		RetentionDays:           getEnvInt(getenv, "RETENTION_DAYS", 30),
+		EventTypeReloadInterval: getEnvDuration(getenv, "EVENT_TYPE_RELOAD_INTERVAL", 5*time.Minute),
+		RoutingReloadInterval:   getEnvDuration(getenv, "ROUTING_RULES_RELOAD_INTERVAL", 5*time.Minute),
+		ChannelReloadInterval:   getEnvDuration(getenv, "CHANNEL_RELOAD_INTERVAL", 5*time.Minute),
+		DBStatsInterval:         getEnvDuration(getenv, "DB_STATS_INTERVAL", 15*time.Second),
+
+		ChannelIdleTTL:       getEnvDuration(getenv, "CHANNEL_TTL_IDLE", 24*time.Hour),
+		ChannelNamePattern:   getEnvRegexp(getenv, "CHANNEL_NAME_PATTERN", defaultChannelNamePattern),
+		ReservedChannelNames: getEnvList(getenv, "RESERVED_CHANNEL_NAMES", defaultReservedChannelNames),
+
+		ContentSecurityPolicy: getEnv(getenv, "CONTENT_SECURITY_POLICY", "default-src 'none'"),
+		CORSAllowedOrigins:    getEnvList(getenv, "CORS_ALLOWED_ORIGINS", nil),
+
+		StripFields: getEnvList(getenv, "STRIP_FIELDS", nil),
+	}
+
+	if secret != "" {
+		if err := validateConfig(cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+// Values of APP_ENV. Anything other than development is treated as
+// production.
+const (
+	appEnvProduction  = "production"
+	appEnvDevelopment = "development"
+)
+
+const minJWTSecretLength = 32
+
+// weakJWTSecrets are values seen in tutorials and sample configs. They are
+// compared case-insensitively.
+var weakJWTSecrets = []string{
+	"secret", "password", "changeme", "change-me", "jwt-secret", "jwt_secret",
+	"supersecret", "mysecret", "default", "test", "admin", "12345678",
+}
+
+// validateConfig checks settings that parse but are unsafe. A weak
+// JWT_SECRET fails startup unless APP_ENV=development, where it is only
+// logged so a local setup works with any value.
+func validateConfig(cfg Config) error {
+	reason := ""
+	switch {
+	case slices.Contains(weakJWTSecrets, strings.ToLower(string(cfg.JwtSecret))):
+		reason = "is a well-known default"
+	case len(cfg.JwtSecret) < minJWTSecretLength:
+		reason = fmt.Sprintf("is shorter than %d bytes", minJWTSecretLength)
+	default:
+		return nil
+	}
+
+	if cfg.AppEnv == appEnvDevelopment {
+		slog.Warn("JWT_SECRET is weak; allowed because APP_ENV=development", "reason", reason, "length", len(cfg.JwtSecret))
+		return nil
+	}
+	slog.Error("JWT_SECRET is weak", "reason", reason, "length", len(cfg.JwtSecret), "app_env", cfg.AppEnv)
+	return fmt.Errorf("JWT_SECRET %s; set APP_ENV=development to allow it", reason)
+}
+
+func getEnv(getenv func(string) string, key, def string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvDuration(getenv func(string) string, key string, def time.Duration) time.Duration {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Invalid duration, using default", "key", key, "value", v, "error", err)
+		return def
+	}
+	return d
+}
+
+func getEnvInt(getenv func(string) string, key string, def int) int {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("Invalid integer, using default", "key", key, "value", v, "error", err)
+		return def
+	}
+	return n
+}
+
+func getEnvRateLimitAlgorithm(getenv func(string) string, key, def string) string {
+	switch v := getenv(key); v {
+	case "":
+		return def
+	case rateLimitFixedWindow, rateLimitSlidingWindow:
+		return v
+	default:
+		slog.Warn("Invalid rate limit algorithm, using default", "key", key, "value", v)
+		return def
+	}
+}
+
+func getEnvBackpressure(getenv func(string) string, key string, def BackpressureStrategy) BackpressureStrategy {
+	v := BackpressureStrategy(getenv(key))
+	switch v {
+	case "":
+		return def
+	case BackpressureDrop, BackpressureBlock, BackpressureError:
+		return v
+	}
+	slog.Warn("Invalid backpressure strategy, using default", "key", key, "value", v)
+	return def
+}
+
+func getEnvRegexp(getenv func(string) string, key string, def *regexp.Regexp) *regexp.Regexp {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+
+	re, err := regexp.Compile(v)
+	if err != nil {
+		slog.Warn("Invalid pattern, using default", "key", key, "value", v, "error", err)
+		return def
+	}
+	return re
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(getenv func(string) string, key string, def []string) []string {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEnv returns a getenv reading from env.
+func fakeEnv(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+// validEnv returns the smallest environment loadConfig accepts, plus
+// extra.
+func validEnv(extra map[string]string) map[string]string {
+	env := map[string]string{
+		"JWT_SECRET":   testJWTSecret,
+		"DATABASE_URL": "postgres://localhost/queue",
+	}
+	maps.Copy(env, extra)
+	return env
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{
+			name:     "all required variables missing",
+			env:      map[string]string{},
+			wantErrs: []string{"JWT_SECRET is not set", "DATABASE_URL is not set"},
+		},
+		{
+			name:     "database url missing",
+			env:      map[string]string{"JWT_SECRET": testJWTSecret},
+			wantErrs: []string{"DATABASE_URL is not set"},
+		},
+		{
+			name:     "missing database url and weak secret",
+			env:      map[string]string{"JWT_SECRET": "secret"},
+			wantErrs: []string{"DATABASE_URL is not set", "JWT_SECRET is a well-known default"},
+		},
+		{
+			name: "weak secret in development",
+			env:  validEnv(map[string]string{"JWT_SECRET": "changeme", "APP_ENV": "development"}),
+		},
+		{
+			name: "valid",
+			env:  validEnv(nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(fakeEnv(tt.env))
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("loadConfig: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("loadConfig succeeded, want %q", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+			if got := len(strings.Split(err.Error(), "\n")); got != len(tt.wantErrs) {
+				t.Errorf("error %q has %d problems, want %d", err, got, len(tt.wantErrs))
+			}
+		})
+	}
+}
+
+func TestLoadConfigValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, cfg Config)
+	}{
+		{
+			name: "defaults",
+			env:  validEnv(nil),
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Port != "8080" || cfg.AppEnv != appEnvProduction || cfg.HeartbeatInterval != 15*time.Second ||
+					cfg.BackpressureStrategy != BackpressureDrop || cfg.SSERetry != 3*time.Second {
+					t.Errorf("cfg = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "overrides",
+			env: validEnv(map[string]string{
+				"PORT":                  "9000",
+				"HEARTBEAT_INTERVAL":    "5s",
+				"BACKPRESSURE_STRATEGY": "block",
+				"SSE_RETRY_MS":          "500",
+				"CORS_ALLOWED_ORIGINS":  " https://a.example, ,https://b.example ",
+				"USE_SYNC_MAP":          "true",
+			}),
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Port != "9000" || cfg.HeartbeatInterval != 5*time.Second || cfg.BackpressureStrategy != BackpressureBlock ||
+					cfg.SSERetry != 500*time.Millisecond || !cfg.UseSyncMap {
+					t.Errorf("cfg = %+v", cfg)
+				}
+				if got := strings.Join(cfg.CORSAllowedOrigins, "|"); got != "https://a.example|https://b.example" {
+					t.Errorf("CORSAllowedOrigins = %q", cfg.CORSAllowedOrigins)
+				}
+			},
+		},
+		{
+			name: "invalid optional values fall back",
+			env: validEnv(map[string]string{
+				"HEARTBEAT_INTERVAL":    "soon",
+				"SSE_RETRY_MS":          "fast",
+				"BACKPRESSURE_STRATEGY": "panic",
+				"RATE_LIMIT_ALGORITHM":  "leaky",
+				"CHANNEL_NAME_PATTERN":  "[",
+			}),
+			check: func(t *testing.T, cfg Config) {
+				if cfg.HeartbeatInterval != 15*time.Second || cfg.SSERetry != 3*time.Second ||
+					cfg.BackpressureStrategy != BackpressureDrop || cfg.RateLimitAlgorithm != rateLimitFixedWindow ||
+					cfg.ChannelNamePattern != defaultChannelNamePattern {
+					t.Errorf("cfg = %+v", cfg)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(fakeEnv(tt.env))
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestValidateConfigJWTSecret(t *testing.T) {
+	long := strings.Repeat("k", minJWTSecretLength)
+	tests := []struct {
+		name      string
+		secret    string
+		appEnv    string
+		wantErr   string
+		wantLevel string // of the line logged, if any
+	}{
+		{name: "strong", secret: long, appEnv: appEnvProduction},
+		{name: "denylisted", secret: "secret", appEnv: appEnvProduction,
+			wantErr: "is a well-known default", wantLevel: "ERROR"},
+		{name: "denylisted in another case", secret: "PassWord", appEnv: appEnvProduction,
+			wantErr: "is a well-known default", wantLevel: "ERROR"},
+		{name: "one byte short", secret: long[1:], appEnv: appEnvProduction,
+			wantErr: "is shorter than 32 bytes", wantLevel: "ERROR"},
+		{name: "other environment", secret: "changeme", appEnv: "staging",
+			wantErr: "is a well-known default", wantLevel: "ERROR"},
+		{name: "no environment", secret: "changeme",
+			wantErr: "is a well-known default", wantLevel: "ERROR"},
+		{name: "denylisted in development", secret: "changeme", appEnv: appEnvDevelopment, wantLevel: "WARN"},
+		{name: "short in development", secret: "l0cal-key", appEnv: appEnvDevelopment, wantLevel: "WARN"},
+		{name: "strong in development", secret: long, appEnv: appEnvDevelopment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer slog.SetDefault(slog.Default())
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+			err := validateConfig(Config{AppEnv: tt.appEnv, JwtSecret: []byte(tt.secret)})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validateConfig: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+
+			out := logs.String()
+			if tt.wantLevel == "" {
+				if out != "" {
+					t.Errorf("logged %q, want nothing", out)
+				}
+				return
+			}
+			if !strings.Contains(out, "level="+tt.wantLevel) || strings.Count(out, "\n") != 1 {
+				t.Errorf("logged %q, want one %s line", out, tt.wantLevel)
+			}
+			if strings.Contains(out, tt.secret) {
+				t.Errorf("log %q contains the secret", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsAllowedMethods and corsAllowedHeaders are what a preflight is told
+// cross-origin requests may use, covering every endpoint.
+var (
+	corsAllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPatch, http.MethodDelete}
+	corsAllowedHeaders = []string{"Authorization", "Content-Type", "Content-Encoding", "Last-Event-ID", "X-Request-ID", "X-Session-ID"}
+)
+
+const corsMaxAge = 10 * time.Minute
+
+// corsMiddleware wraps every route. It answers preflights itself, since
+// they carry no token and would otherwise be refused by allowMethods or
+// auth, and lets listed origins read the responses of actual requests.
+// Requests from other origins still reach the handlers without CORS
+// headers, so browsers keep their responses from the page; /events
+// refuses them outright in originMiddleware.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := s.originAllowed(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			s.logger.Warn("Rejected preflight from disallowed origin", "origin", origin, "path", r.URL.Path)
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// originMiddleware enforces the CORS allowlist on every request, not only
+// preflights. Browsers attach cookies to cross-origin EventSource requests,
+// so a stream opened from an unlisted origin could leak a victim's events.
+// Requests without an Origin header (curl, server-to-server) pass through.
+// The CORS response headers are set by corsMiddleware.
+func (s *Server) originMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !s.originAllowed(origin) {
+			s.logger.Warn("Rejected request from disallowed origin", "origin", origin, "path", r.URL.Path)
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// originAllowed reports whether origin is in CORS_ALLOWED_ORIGINS. The
+// entry "*" allows any origin.
+func (s *Server) originAllowed(origin string) bool {
+	return slices.Contains(s.config.CORSAllowedOrigins, "*") ||
+		slices.Contains(s.config.CORSAllowedOrigins, origin)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	cfg := testConfig()
+	cfg.CORSAllowedOrigins = []string{"https://app.example"}
+	s, fake, _ := newTestServer(t, cfg)
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		origin        string
+		preflight     bool
+		wantStatus    int
+		wantOrigin    string
+		wantPreflight bool
+	}{
+		{
+			name:       "no origin",
+			method:     http.MethodGet,
+			target:     "/metrics",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:          "allowed preflight",
+			method:        http.MethodOptions,
+			target:        "/trigger",
+			origin:        "https://app.example",
+			preflight:     true,
+			wantStatus:    http.StatusNoContent,
+			wantOrigin:    "https://app.example",
+			wantPreflight: true,
+		},
+		{
+			name:       "disallowed preflight",
+			method:     http.MethodOptions,
+			target:     "/trigger",
+			origin:     "https://evil.example",
+			preflight:  true,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "allowed request",
+			method:     http.MethodGet,
+			target:     "/metrics",
+			origin:     "https://app.example",
+			wantStatus: http.StatusOK,
+			wantOrigin: "https://app.example",
+		},
+		{
+			name:       "disallowed request",
+			method:     http.MethodGet,
+			target:     "/metrics",
+			origin:     "https://evil.example",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "disallowed events",
+			method:     http.MethodGet,
+			target:     "/events",
+			origin:     "https://evil.example",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(fake.Queries())
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+			}
+
+			w := serve(s, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.origin != "" && w.Header().Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin", w.Header().Values("Vary"))
+			}
+			if tt.preflight && len(fake.Queries()) != before {
+				t.Errorf("preflight queried the database: %v", fake.Queries()[before:])
+			}
+
+			wantHeaders := map[string]string{
+				"Access-Control-Allow-Methods": "GET, HEAD, POST, PATCH, DELETE",
+				"Access-Control-Allow-Headers": "Authorization, Content-Type, Content-Encoding, Last-Event-ID, X-Request-ID, X-Session-ID",
+				"Access-Control-Max-Age":       "600",
+			}
+			for name, want := range wantHeaders {
+				if !tt.wantPreflight {
+					want = ""
+				}
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// debugPage is rendered with the names of the events to listen for.
+// EventSource only hands named events to listeners registered for that
+// name, so onmessage alone would show nothing but legacy trigger events.
+var debugPage = template.Must(template.New("debug").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>peeple-queue events</title>
+</head>
+<body>
+<pre id="log"></pre>
+<script>
+const log = document.getElementById("log");
+const source = new EventSource(location.pathname + location.search);
+const show = (e) => { log.textContent += "[" + e.type + "] " + e.data + "\n"; };
+source.onmessage = show;
+for (const name of {{.}}) {
+	source.addEventListener(name, show);
+}
+source.onerror = () => { log.textContent += "[connection error]\n"; };
+</script>
+</body>
+</html>
+`))
+
+// builtinEventNames are the named events the server sends itself, on top
+// of the configured event types.
+var builtinEventNames = []string{
+	"ping", "close", messageEventType, "notify",
+	logoutEventType, disconnectEventType, userStatusEventType, testEventType,
+}
+
+// debugEventNames returns the event names the debug page listens for.
+func (s *Server) debugEventNames() []string {
+	names := append(slices.Clone(builtinEventNames), s.eventTypes.List()...)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// prefersHTML reports whether the Accept header ranks text/html above
+// text/event-stream, as browsers do for a top-level navigation.
+func prefersHTML(r *http.Request) bool {
+	var htmlQ, sseQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		case "text/event-stream":
+			sseQ = max(sseQ, q)
+		}
+	}
+	return htmlQ > sseQ
+}
+
+// debugUIMiddleware serves the debug page to browsers ahead of auth, since
+// a top-level navigation cannot carry a Bearer token.
+func (s *Server) debugUIMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.EnableDebugUI || !prefersHTML(r) {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := debugPage.Execute(w, s.debugEventNames()); err != nil {
+			s.logger.Warn("Failed to render debug page", "error", err)
+		}
+	}
+}
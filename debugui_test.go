@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugPageListensForNamedEvents(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnableDebugUI = true
+	s, _, _ := newTestServer(t, cfg)
+	s.eventTypes.set([]string{"order_shipped", "notification"})
+
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+	w := serve(s, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "addEventListener(name, show)") {
+		t.Fatalf("page registers no named event listeners:\n%s", body)
+	}
+	for _, name := range []string{"order_shipped", "notification", "ping", "close", "logout", "user_status_changed", "__test__"} {
+		if !strings.Contains(body, `"`+name+`"`) {
+			t.Errorf("page does not listen for %q", name)
+		}
+	}
+}
+
+func TestPrefersHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"text/html,application/xhtml+xml,*/*;q=0.8", true},
+		{"text/event-stream", false},
+		{"text/html;q=0.5, text/event-stream", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/events", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := prefersHTML(r); got != tt.want {
+			t.Errorf("prefersHTML(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
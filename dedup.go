@@ -0,0 +1,67 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// dedupRingSize bounds how many recent events deduplication remembers.
+// Past that many triggers within one window, the oldest are forgotten
+// early and their duplicates go through.
+const dedupRingSize = 1024
+
+type dedupEntry struct {
+	hash    uint64
+	eventID string
+	expires time.Time
+}
+
+// dedupRing remembers the hashes of recently triggered events in a fixed
+// ring, overwriting the oldest entry with each new one.
+type dedupRing struct {
+	mu      sync.Mutex
+	entries [dedupRingSize]dedupEntry
+	next    int
+}
+
+// claim returns the ID of an unexpired event with the same hash, if there
+// is one. Otherwise it records eventID under hash until now+window.
+func (d *dedupRing) claim(hash uint64, eventID string, now time.Time, window time.Duration) (original string, dup bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, e := range d.entries {
+		if e.hash == hash && e.eventID != "" && now.Before(e.expires) {
+			return e.eventID, true
+		}
+	}
+	d.entries[d.next] = dedupEntry{hash: hash, eventID: eventID, expires: now.Add(window)}
+	d.next = (d.next + 1) % dedupRingSize
+	return "", false
+}
+
+// release forgets the entry claim recorded for eventID under hash, so an
+// event that failed after claiming does not turn its retries into
+// duplicates of something never stored or sent.
+func (d *dedupRing) release(hash uint64, eventID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, e := range d.entries {
+		if e.hash == hash && e.eventID == eventID {
+			d.entries[i] = dedupEntry{}
+		}
+	}
+}
+
+// dedupHash identifies a trigger by what subscribers would receive:
+// channel, event type and payload.
+func dedupHash(req TriggerRequest) uint64 {
+	h := fnv.New64a()
+	for _, part := range [][]byte{[]byte(req.Channel), []byte(req.EventType), req.Payload, []byte(req.Message)} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
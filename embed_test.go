@@ -0,0 +1,181 @@
+package main_test
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	queue "github.com/arnnvv/peeple-queue"
+)
+
+// These tests use only the exported API, as an application embedding the
+// server would. They run against emptyDB, since the fakeDB of the
+// package's own tests is not visible from here.
+
+// emptyDB is a database/sql connector whose queries return one row of
+// zeros and whose statements affect nothing.
+type emptyDB struct{}
+
+func (emptyDB) Connect(context.Context) (driver.Conn, error) { return emptyConn{}, nil }
+func (emptyDB) Driver() driver.Driver                        { return nil }
+
+type emptyConn struct{}
+
+func (emptyConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("emptyDB: prepared statements are not supported")
+}
+func (emptyConn) Close() error { return nil }
+func (emptyConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("emptyDB: transactions are not supported")
+}
+
+func (emptyConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &zeroRow{}, nil
+}
+
+func (emptyConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+type zeroRow struct{ done bool }
+
+func (r *zeroRow) Columns() []string { return []string{"value"} }
+func (r *zeroRow) Close() error      { return nil }
+
+func (r *zeroRow) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(0)
+	return nil
+}
+
+func newEmbeddedServer(t *testing.T) (*queue.Server, *httptest.Server) {
+	t.Helper()
+
+	cfg := queue.Config{
+		JwtSecret:            []byte("embedded-test-secret-long-enough-for-validation"),
+		SSERetry:             3 * time.Second,
+		ShutdownSSETimeout:   time.Second,
+		ShutdownAPITimeout:   time.Second,
+		BackpressureStrategy: queue.BackpressureDrop,
+		MaxPayloadBytes:      64 << 10,
+		ChannelNamePattern:   regexp.MustCompile(`^[a-z]+$`),
+	}
+	srv := queue.NewServer(sql.OpenDB(emptyDB{}), cfg,
+		queue.WithLogger(slog.New(slog.DiscardHandler)),
+		queue.WithMessageStore(queue.NewMemoryMessageStore()))
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// subscribe opens a stream on channel, reads past the connected event
+// and returns a reader positioned at the next one.
+func subscribe(t *testing.T, ts *httptest.Server, channel string) *bufio.Reader {
+	t.Helper()
+
+	resp, err := http.Get(ts.URL + "/events?channel=" + channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	br := bufio.NewReader(resp.Body)
+	nextEvent(t, br)
+	return br
+}
+
+// nextEvent returns the event and data lines of the next event on br.
+func nextEvent(t *testing.T, br *bufio.Reader) (event, data string) {
+	t.Helper()
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestBroadcastFromEmbeddingPackage(t *testing.T) {
+	srv, ts := newEmbeddedServer(t)
+	clients := []*bufio.Reader{subscribe(t, ts, "room"), subscribe(t, ts, "room")}
+	for deadline := time.Now().Add(time.Second); srv.SubscriberCount("room") < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 2 clients subscribed", srv.SubscriberCount("room"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		name          string
+		broadcast     func() (int, int)
+		wantDelivered int
+		wantData      string
+	}{
+		{
+			name:          "bytes",
+			broadcast:     func() (int, int) { return srv.BroadcastBytes("room", "greeting", []byte(`{"text":"hi"}`)) },
+			wantDelivered: 2,
+			wantData:      `{"text":"hi"}`,
+		},
+		{
+			name: "json",
+			broadcast: func() (int, int) {
+				return srv.BroadcastJSON("room", "greeting", struct {
+					Text string `json:"text"`
+				}{"hello"})
+			},
+			wantDelivered: 2,
+			wantData:      `{"text":"hello"}`,
+		},
+		{
+			name:      "unmarshallable json",
+			broadcast: func() (int, int) { return srv.BroadcastJSON("room", "greeting", make(chan int)) },
+		},
+		{
+			name:      "channel without subscribers",
+			broadcast: func() (int, int) { return srv.BroadcastBytes("empty", "greeting", []byte(`{}`)) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delivered, dropped := tt.broadcast()
+			if delivered != tt.wantDelivered || dropped != 0 {
+				t.Fatalf("delivered, dropped = %d, %d, want %d, 0", delivered, dropped, tt.wantDelivered)
+			}
+			if tt.wantDelivered == 0 {
+				return
+			}
+			for i, br := range clients {
+				event, data := nextEvent(t, br)
+				if event != "greeting" || data != tt.wantData {
+					t.Errorf("client %d got event %q data %s, want greeting %s", i, event, data, tt.wantData)
+				}
+			}
+		})
+	}
+}
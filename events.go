@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const purgeBatchSize = 10000
+
+// eventHistoryHandler returns one stored event, which is what the
+// Location header of /trigger points at. Events that are scheduled or
+// queued are saved when they go out, so they answer 404 until then.
+func (s *Server) eventHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	e, err := s.store.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrEventNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "event_not_found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up event", "event_id", r.PathValue("id"), "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]any{
+		"event_id":   e.EventID,
+		"channel":    e.Channel,
+		"event_type": e.EventType,
+		"message":    json.RawMessage(e.Message),
+		"created_at": e.CreatedAt.UTC().Format(time.RFC3339),
+		"delivered":  !e.DeliveredAt.IsZero(),
+	}
+	if !e.DeliveredAt.IsZero() {
+		resp["delivered_at"] = e.DeliveredAt.UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// eventsBetween returns up to limit events of channel created in
+// [since, before), oldest first.
+func (s *Server) eventsBetween(ctx context.Context, channel string, since, before time.Time, limit int) ([]Event, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT event_id, channel, event_type, message FROM events
+		WHERE channel = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY id
+		LIMIT $4`, channel, since, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.EventID, &e.Channel, &e.EventType, &e.Message); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+type purgeResult struct {
+	Deleted            int64 `json:"deleted"`
+	FreedBytesEstimate int64 `json:"freed_bytes_estimate"`
+}
+
+// purgeEvents deletes events created before cutoff, optionally only in
+// channel, in batches of purgeBatchSize so no single statement holds
+// locks for long. With dryRun it only counts what would be deleted.
+func (s *Server) purgeEvents(ctx context.Context, cutoff time.Time, channel string, dryRun bool) (purgeResult, error) {
+	var res purgeResult
+
+	if dryRun {
+		err := s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(pg_column_size(e.*)), 0) FROM events e
+			WHERE created_at < $1 AND ($2 = '' OR channel = $2)`, cutoff, channel).
+			Scan(&res.Deleted, &res.FreedBytesEstimate)
+		return res, err
+	}
+
+	return s.deleteEventBatches(ctx, `
+		WITH deleted AS (
+			DELETE FROM events WHERE id IN (
+				SELECT id FROM events
+				WHERE created_at < $1 AND ($2 = '' OR channel = $2)
+				LIMIT $3
+			)
+			RETURNING pg_column_size(events.*) AS size
+		)
+		SELECT COUNT(*), COALESCE(SUM(size), 0) FROM deleted`, cutoff, channel, purgeBatchSize)
+}
+
+// purgeExpiredEvents deletes events that have outlived their channel's
+// retention_days, or RetentionDays for channels without one. A retention
+// of 0 at either level keeps the events it covers forever.
+func (s *Server) purgeExpiredEvents(ctx context.Context, now time.Time) (purgeResult, error) {
+	return s.deleteEventBatches(ctx, `
+		WITH deleted AS (
+			DELETE FROM events WHERE id IN (
+				SELECT e.id FROM events e
+				LEFT JOIN channels c ON c.name = e.channel
+				WHERE e.created_at < $1 - make_interval(days => COALESCE(c.retention_days, $2))
+				  AND COALESCE(c.retention_days, $2) > 0
+				LIMIT $3
+			)
+			RETURNING pg_column_size(events.*) AS size
+		)
+		SELECT COUNT(*), COALESCE(SUM(size), 0) FROM deleted`, now, s.config.RetentionDays, purgeBatchSize)
+}
+
+// deleteEventBatches runs query, a batched DELETE that reports how many
+// rows it removed and their size, until a batch comes back short of
+// purgeBatchSize.
+func (s *Server) deleteEventBatches(ctx context.Context, query string, args ...any) (purgeResult, error) {
+	var res purgeResult
+	for {
+		var n, size int64
+		if err := s.db.QueryRowContext(ctx, query, args...).Scan(&n, &size); err != nil {
+			return res, err
+		}
+
+		res.Deleted += n
+		res.FreedBytesEstimate += size
+		if n < purgeBatchSize {
+			return res, nil
+		}
+	}
+}
+
+// runRetentionCleanup purges expired events once a day; see
+// purgeExpiredEvents for how the age limit of each channel is chosen.
+func (s *Server) runRetentionCleanup() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			res, err := s.purgeExpiredEvents(s.ctx, s.clock.Now())
+			if err != nil {
+				s.logger.Error("Retention cleanup failed", "error", err)
+				continue
+			}
+			s.logger.Info("Retention cleanup finished", "deleted", res.Deleted)
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// parseAge accepts Go durations plus a "d" suffix for whole days, e.g.
+// "24h", "7d" or "30d".
+func parseAge(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid day count %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventHistory(t *testing.T) {
+	s, _, _ := newTestServer(t, testConfig())
+
+	w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+		strings.NewReader(`{"event_type":"notification","payload":{"text":"hi"}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("trigger: status %d, body %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/v1/events/history/") {
+		t.Fatalf("Location = %q", location)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantBody   []string
+	}{
+		{
+			name:       "triggered event",
+			target:     location,
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"delivered":true`, `"event_type":"notification"`, `"text":"hi"`},
+		},
+		{
+			name:       "unknown id",
+			target:     "/v1/events/history/00000000-0000-0000-0000-000000000000",
+			wantStatus: http.StatusNotFound,
+			wantBody:   []string{`"event_not_found"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s, authRequest(t, http.MethodGet, tt.target, 1, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body %s does not contain %s", w.Body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventHistoryRequiresToken(t *testing.T) {
+	s, _, _ := newTestServer(t, testConfig())
+
+	w := serve(s, httptest.NewRequest(http.MethodGet, "/v1/events/history/x", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+}
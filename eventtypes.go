@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// eventTypePattern is what an event_type may look like. The type becomes
+// the event: line of an SSE frame, so it must never carry a line break,
+// and the other characters are kept to what EventSource listeners and log
+// queries handle without quoting.
+var eventTypePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// validEventType reports whether eventType may be sent to clients.
+func validEventType(eventType string) bool {
+	return eventTypePattern.MatchString(eventType)
+}
+
+// eventTypeRegistry holds the allowed event_type values from the
+// event_types table. An empty registry allows every type, so the
+// restriction is opt-in.
+type eventTypeRegistry struct {
+	mu    sync.RWMutex
+	types []string
+}
+
+func (e *eventTypeRegistry) Allowed(eventType string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.types) == 0 {
+		return true
+	}
+	_, found := slices.BinarySearch(e.types, eventType)
+	return found
+}
+
+func (e *eventTypeRegistry) List() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.types)
+}
+
+func (e *eventTypeRegistry) set(types []string) {
+	slices.Sort(types)
+	e.mu.Lock()
+	e.types = types
+	e.mu.Unlock()
+}
+
+func (s *Server) loadEventTypes(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM event_types")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var types []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		types = append(types, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.eventTypes.set(types)
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeQuery is one statement the code under test sent to a fakeDB.
+type fakeQuery struct {
+	SQL  string
+	Args []driver.Value
+}
+
+// fakeResult is how a fakeDB answers a statement. A query with no rows
+// makes QueryRow return sql.ErrNoRows.
+type fakeResult struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+}
+
+// fakeDB is a database/sql driver for tests that answers every statement
+// through a handler instead of PostgreSQL and records what it was sent.
+type fakeDB struct {
+	mu      sync.Mutex
+	handle  func(q fakeQuery) fakeResult
+	queries []fakeQuery
+}
+
+// newFakeDB returns a *sql.DB backed by a fakeDB answering with handle,
+// or with empty results when handle is nil.
+func newFakeDB(t testing.TB, handle func(q fakeQuery) fakeResult) (*sql.DB, *fakeDB) {
+	f := &fakeDB{handle: handle}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+	return db, f
+}
+
+// setHandler replaces the handler answering statements.
+func (f *fakeDB) setHandler(handle func(q fakeQuery) fakeResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handle = handle
+}
+
+// Queries returns the statements run so far.
+func (f *fakeDB) Queries() []fakeQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeQuery(nil), f.queries...)
+}
+
+// countQueries returns how many statements run so far contain substr.
+func (f *fakeDB) countQueries(substr string) int {
+	n := 0
+	for _, q := range f.Queries() {
+		if strings.Contains(q.SQL, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeDB) run(query string, args []driver.NamedValue) fakeResult {
+	q := fakeQuery{SQL: query}
+	for _, a := range args {
+		q.Args = append(q.Args, a.Value)
+	}
+
+	f.mu.Lock()
+	f.queries = append(f.queries, q)
+	handle := f.handle
+	f.mu.Unlock()
+
+	if handle == nil {
+		return fakeResult{}
+	}
+	return handle(q)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakeDriver: use sql.OpenDB")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.db.run(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &fakeRows{columns: res.Columns, rows: res.Rows}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.db.run(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+// CheckNamedValue passes through arguments the default converter
+// rejects, such as the []string given to = ANY($1), as pgx does.
+func (c fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value); err == nil {
+		nv.Value = v
+	}
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return fakeConn{s.db}.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeConn{s.db}.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if r.columns == nil && len(r.rows) > 0 {
+		return make([]string, len(r.rows[0]))
+	}
+	return r.columns
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// fakeRow answers a query with a single row of values.
+func fakeRow(values ...driver.Value) fakeResult {
+	return fakeResult{Rows: [][]driver.Value{values}}
+}
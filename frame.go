@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame is a single SSE message. Empty fields are omitted on the wire.
+// One Frame is shared by every client a broadcast reaches, each writing
+// it in the format it asked for.
+type Frame struct {
+	Event string
+	Data  []byte
+	ID    string
+	Retry time.Duration
+
+	ndjsonOnce sync.Once
+	ndjson     []byte
+}
+
+// ndjsonFrame is the NDJSON encoding of a Frame. JSON data is embedded
+// as is; anything else becomes a string.
+type ndjsonFrame struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Data  any    `json:"data"`
+}
+
+// WriteNDJSON writes f as one line of JSON. Retry has no meaning outside
+// SSE and is left out. The line is encoded once per frame however many
+// clients write it.
+func (f *Frame) WriteNDJSON(w io.Writer) (int64, error) {
+	f.ndjsonOnce.Do(func() {
+		line := ndjsonFrame{ID: f.ID, Event: f.Event, Data: string(f.Data)}
+		var compact bytes.Buffer
+		if json.Compact(&compact, f.Data) == nil {
+			line.Data = json.RawMessage(compact.Bytes())
+		}
+		f.ndjson, _ = jsonMarshal(line)
+		f.ndjson = append(f.ndjson, '\n')
+	})
+
+	n, err := w.Write(f.ndjson)
+	if bw, ok := w.(*bufio.Writer); ok && err == nil {
+		err = bw.Flush()
+	}
+	return int64(n), err
+}
+
+// indented returns a copy of f with its data indented by two spaces, or f
+// itself when the data is not JSON. The copy spans several data: lines,
+// which clients join back with newlines, so no escaping is needed.
+func (f *Frame) indented() *Frame {
+	var buf bytes.Buffer
+	if json.Indent(&buf, f.Data, "", "  ") != nil {
+		return f
+	}
+	return &Frame{Event: f.Event, Data: buf.Bytes(), ID: f.ID, Retry: f.Retry}
+}
+
+// WriteTo writes f in SSE wire format. Fields are written straight into a
+// bufio.Writer (w itself if it already is one) and flushed once, so a
+// frame costs a single write to the underlying connection.
+func (f *Frame) WriteTo(w io.Writer) (int64, error) {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriterSize(w, len(f.Data)+64)
+	}
+	start := bw.Buffered()
+
+	if f.Event != "" {
+		writeField(bw, "event", f.Event)
+	}
+	if f.ID != "" {
+		writeField(bw, "id", f.ID)
+	}
+	if f.Retry > 0 {
+		bw.WriteString("retry: ")
+		bw.WriteString(strconv.FormatInt(f.Retry.Milliseconds(), 10))
+		bw.WriteByte('\n')
+	}
+
+	// Each line of data needs its own "data:" prefix or the frame breaks.
+	// EventSource ends lines at CR as well as LF, so both split the data.
+	data := f.Data
+	if bytes.IndexByte(data, '\r') >= 0 {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte{'\n'})
+		data = bytes.ReplaceAll(data, []byte{'\r'}, []byte{'\n'})
+	}
+	for {
+		line, rest, more := bytes.Cut(data, []byte{'\n'})
+		bw.WriteString("data: ")
+		bw.Write(line)
+		bw.WriteByte('\n')
+		if !more {
+			break
+		}
+		data = rest
+	}
+	bw.WriteByte('\n')
+
+	n := int64(bw.Buffered() - start)
+	return n, bw.Flush()
+}
+
+// fieldBreaks removes line breaks from a field value.
+var fieldBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// writeField writes one name: value line. A CR or LF in value would end
+// the line early and let the rest of it pose as fields or frames of its
+// own, so they are dropped.
+func writeField(bw *bufio.Writer, name, value string) {
+	if strings.ContainsAny(value, "\r\n") {
+		value = fieldBreaks.Replace(value)
+	}
+	bw.WriteString(name)
+	bw.WriteString(": ")
+	bw.WriteString(value)
+	bw.WriteByte('\n')
+}
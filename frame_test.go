@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFrameWriteTo(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *Frame
+		want  string
+	}{
+		{
+			name:  "data only",
+			frame: &Frame{Data: []byte(`{"number":1}`)},
+			want:  "data: {\"number\":1}\n\n",
+		},
+		{
+			name:  "all fields",
+			frame: &Frame{Event: "notification", ID: "42", Retry: 3 * time.Second, Data: []byte("hi")},
+			want:  "event: notification\nid: 42\nretry: 3000\ndata: hi\n\n",
+		},
+		{
+			name:  "multi-line data",
+			frame: &Frame{Data: []byte("a\nb\n")},
+			want:  "data: a\ndata: b\ndata: \n\n",
+		},
+		{
+			name:  "line breaks in event and id",
+			frame: &Frame{Event: "x\ndata: forged\n\nevent: close", ID: "1\r\n2", Data: []byte("hi")},
+			want:  "event: xdata: forgedevent: close\nid: 12\ndata: hi\n\n",
+		},
+		{
+			name:  "carriage returns in data",
+			frame: &Frame{Data: []byte("a\r\nb\r\revent: close")},
+			want:  "data: a\ndata: b\ndata: \ndata: event: close\n\n",
+		},
+		{
+			name:  "empty data",
+			frame: &Frame{Event: "ping"},
+			want:  "event: ping\ndata: \n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := tt.frame.WriteTo(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("wrote %q, want %q", buf.String(), tt.want)
+			}
+			if n != int64(len(tt.want)) {
+				t.Errorf("n = %d, want %d", n, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestFrameWriteToBufferedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	bw.WriteString("pending")
+
+	f := &Frame{Event: "ping", Data: []byte("x")}
+	n, err := f.WriteTo(bw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "pendingevent: ping\ndata: x\n\n"; buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+	if n != int64(len("event: ping\ndata: x\n\n")) {
+		t.Errorf("n = %d counts bytes buffered before the frame", n)
+	}
+}
+
+func TestFrameWriteNDJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *Frame
+		want  string
+	}{
+		{"json data", &Frame{ID: "1", Event: "e", Data: []byte(`{ "a": 1 }`)}, `{"id":"1","event":"e","data":{"a":1}}` + "\n"},
+		{"text data", &Frame{Data: []byte("hello")}, `{"data":"hello"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := tt.frame.WriteNDJSON(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+var benchFrame = &Frame{Event: "notification", ID: "6f1c1e9e-2f1d-4a51-9f3a-3f0b8e5d2c11", Data: []byte(`{"event_id":"6f1c1e9e-2f1d-4a51-9f3a-3f0b8e5d2c11","payload":{"text":"hello"}}`)}
+
+func BenchmarkFrameWriteTo(b *testing.B) {
+	bw := bufio.NewWriter(io.Discard)
+	b.ReportAllocs()
+	for b.Loop() {
+		benchFrame.WriteTo(bw)
+	}
+}
+
+// BenchmarkFrameFprintf is the fmt.Fprintf formatting WriteTo replaced,
+// kept as the baseline it is compared against.
+func BenchmarkFrameFprintf(b *testing.B) {
+	bw := bufio.NewWriter(io.Discard)
+	b.ReportAllocs()
+	for b.Loop() {
+		fmt.Fprintf(bw, "event: %s\n", benchFrame.Event)
+		fmt.Fprintf(bw, "id: %s\n", benchFrame.ID)
+		fmt.Fprintf(bw, "data: %s\n\n", benchFrame.Data)
+		bw.Flush()
+	}
+}
+
+func TestFrameIndented(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"object", `{"a":1,"b":[true]}`, "{\n  \"a\": 1,\n  \"b\": [\n    true\n  ]\n}"},
+		{"scalar", `42`, `42`},
+		{"not json", `hello`, `hello`},
+		{"empty", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Frame{Event: "e", ID: "1", Retry: time.Second, Data: []byte(tt.data)}
+			got := f.indented()
+			if string(got.Data) != tt.want {
+				t.Errorf("data = %q, want %q", got.Data, tt.want)
+			}
+			if got.Event != f.Event || got.ID != f.ID || got.Retry != f.Retry {
+				t.Errorf("fields = %+v, want those of %+v", got, f)
+			}
+			if string(f.Data) != tt.data {
+				t.Errorf("shared frame changed to %q", f.Data)
+			}
+		})
+	}
+}
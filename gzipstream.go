@@ -0,0 +1,59 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipStream compresses a stream and makes every Flush push the frames
+// written so far through to the client, so compression never holds an
+// event back.
+type gzipStream struct {
+	zw      *gzip.Writer
+	flusher http.Flusher
+}
+
+// newGzipStream compresses into w with gzip.BestSpeed, which keeps the
+// per-frame cost low; events are small and latency matters more than
+// the last few percent of ratio.
+func newGzipStream(w io.Writer, flusher http.Flusher) *gzipStream {
+	zw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	return &gzipStream{zw: zw, flusher: flusher}
+}
+
+func (g *gzipStream) Write(p []byte) (int, error) {
+	return g.zw.Write(p)
+}
+
+func (g *gzipStream) Flush() {
+	g.zw.Flush()
+	g.flusher.Flush()
+}
+
+// close writes the gzip trailer. It must run before the underlying
+// stream is closed.
+func (g *gzipStream) close() {
+	g.zw.Close()
+	g.flusher.Flush()
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip with
+// a non-zero quality.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
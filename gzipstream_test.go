@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"br, deflate", false},
+		{"x-gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/events", nil)
+			r.Header.Set("Accept-Encoding", tt.header)
+			if got := acceptsGzip(r); got != tt.want {
+				t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGzipStream(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		http2          bool
+		wantGzip       bool
+	}{
+		{name: "accepted", acceptEncoding: "gzip", wantGzip: true},
+		{name: "not accepted", acceptEncoding: "identity"},
+		{name: "HTTP/2", acceptEncoding: "gzip", http2: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestServer(t, testConfig())
+			ts := httptest.NewUnstartedServer(s)
+			ts.EnableHTTP2 = tt.http2
+			ts.StartTLS()
+			t.Cleanup(ts.Close)
+
+			// The header is set by hand and the transport told not to
+			// decompress, so the test sees the bytes on the wire.
+			transport := ts.Client().Transport.(*http.Transport).Clone()
+			transport.ForceAttemptHTTP2 = tt.http2
+			transport.DisableCompression = true
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/events?channel=room", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			resp, err := (&http.Client{Transport: transport}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { resp.Body.Close() })
+
+			if gzipped := resp.Header.Get("Content-Encoding") == "gzip"; gzipped != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", resp.Header.Get("Content-Encoding"), tt.wantGzip)
+			}
+			var body io.Reader = resp.Body
+			if tt.wantGzip {
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			br := bufio.NewReader(body)
+
+			// Each frame must arrive decodable on its own, not only when
+			// the stream ends.
+			if ev := readEvent(t, br); !strings.Contains(ev.Data, `"status":"connected"`) {
+				t.Fatalf("first event = %+v, want connected", ev)
+			}
+			text := strings.Repeat("large payload ", 500)
+			w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+				strings.NewReader(`{"channel":"room","event_type":"notification","payload":{"text":"`+text+`"}}`)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("trigger: status %d, body %s", w.Code, w.Body)
+			}
+			if ev := readEvent(t, br); ev.Event != "notification" || !strings.Contains(ev.Data, `"text":"`+text+`"`) {
+				t.Errorf("event = %.200s, want the triggered payload intact", ev.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// hijackedStream is an SSE response written straight to the client's
+// connection after taking it over from net/http. It reimplements the
+// little HTTP/1.1 framing a stream needs: a status line, the headers and
+// a chunked body.
+type hijackedStream struct {
+	conn    net.Conn
+	brw     *bufio.ReadWriter
+	chunked io.WriteCloser
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// err is the first error flushing to the connection returned.
+	err error
+	// writeTimeout, if set, bounds every write so that a client that
+	// stops reading fails instead of blocking the writer.
+	writeTimeout time.Duration
+}
+
+// hijackStream takes over the connection of an HTTP/1.1 request, sets
+// TCP_NODELAY on it and writes the response header from w. An error means
+// the connection was not taken over and w can still be used.
+//
+// net/http no longer watches a hijacked connection. The returned stream's
+// context is cancelled once watch sees the client close its side, or when
+// the stream is closed.
+func (s *Server) hijackStream(w http.ResponseWriter, r *http.Request) (*hijackedStream, error) {
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Deadlines set by the http.Server would cut the stream off.
+	conn.SetDeadline(time.Time{})
+	if err := setNoDelay(conn); err != nil {
+		s.logger.Debug("Could not set TCP_NODELAY", "error", err)
+	}
+
+	// The connection is closed when the stream ends, so it is never
+	// handed back for another request.
+	h := w.Header()
+	h.Set("Connection", "close")
+	h.Set("Transfer-Encoding", "chunked")
+	brw.WriteString("HTTP/1.1 200 OK\r\n")
+	h.Write(brw)
+	brw.WriteString("\r\n")
+
+	hs := &hijackedStream{conn: conn, brw: brw, chunked: httputil.NewChunkedWriter(brw)}
+	hs.ctx, hs.cancel = context.WithCancel(r.Context())
+	return hs, nil
+}
+
+// watch cancels the stream's context when the client disconnects. It
+// costs a goroutine blocked reading the connection, which pooled streams
+// do without; they notice a client is gone when writing to it fails.
+func (hs *hijackedStream) watch() {
+	go func() {
+		// The client sends nothing more; a read returning means it went away.
+		io.Copy(io.Discard, hs.brw.Reader)
+		hs.cancel()
+	}()
+}
+
+func (hs *hijackedStream) Write(p []byte) (int, error) {
+	hs.setWriteDeadline()
+	return hs.chunked.Write(p)
+}
+
+// Flush sends buffered chunks to the client. It makes hijackedStream an
+// http.Flusher so the stream loop need not tell the two paths apart.
+func (hs *hijackedStream) Flush() {
+	hs.setWriteDeadline()
+	if err := hs.brw.Flush(); err != nil && hs.err == nil {
+		hs.err = err
+	}
+}
+
+func (hs *hijackedStream) setWriteDeadline() {
+	if hs.writeTimeout > 0 {
+		hs.conn.SetWriteDeadline(time.Now().Add(hs.writeTimeout))
+	}
+}
+
+// close ends the chunked body and the connection.
+func (hs *hijackedStream) close() {
+	hs.chunked.Close()
+	hs.brw.WriteString("\r\n")
+	hs.brw.Flush()
+	hs.conn.Close()
+	hs.cancel()
+}
+
+// tcpConn returns the TCP connection underneath conn, unwrapping TLS.
+func tcpConn(conn net.Conn) (*net.TCPConn, bool) {
+	if tc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tc.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	return tcp, ok
+}
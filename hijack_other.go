@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setNoDelay falls back to the portable API where raw socket options are
+// not available.
+func setNoDelay(conn net.Conn) error {
+	tcp, ok := tcpConn(conn)
+	if !ok {
+		return errors.New("not a TCP connection")
+	}
+	return tcp.SetNoDelay(true)
+}
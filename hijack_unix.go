@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setNoDelay turns on TCP_NODELAY so each flushed frame is sent at once
+// instead of waiting to be coalesced with the next.
+func setNoDelay(conn net.Conn) error {
+	tcp, ok := tcpConn(conn)
+	if !ok {
+		return errors.New("not a TCP connection")
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const docsURL = "https://github.com/arnnvv/peeple-queue"
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	connKindKey
+	requestIDKey
+	routePrefixKey
+)
+
+// requestIDMiddleware propagates the caller's X-Request-ID, or assigns
+// one, so that it can be echoed in responses and error bodies.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// securityHeadersMiddleware sets headers security scanners expect on every
+// response. X-Frame-Options means nothing for an SSE stream but protects
+// the API endpoints, so it is sent everywhere. The CSP is removed again
+// for SSE routes by withConnKind.
+func securityHeadersMiddleware(csp string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Content-Security-Policy", csp)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// allowMethods rejects requests whose method is not in methods with a 405
+// pointing at the endpoint's section of the README.
+func allowMethods(docsAnchor string, methods []string, next http.HandlerFunc) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
+				"error":      "method_not_allowed",
+				"allowed":    methods,
+				"docs":       docsURL + "#" + docsAnchor,
+				"request_id": RequestIDFromContext(r.Context()),
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := jsonMarshal(v)
+	if err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// decodeJSON reads all of r into v. An empty body yields io.EOF, as
+// json.Decoder would.
+func decodeJSON(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return io.EOF
+	}
+	return jsonUnmarshal(data, v)
+}
+
+var (
+	// errInvalidGzip marks a gzip request body that could not be decoded.
+	errInvalidGzip = errors.New("invalid gzip body")
+	// errUnsupportedEncoding is returned for a Content-Encoding other
+	// than gzip.
+	errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+)
+
+// limitedBody returns r's body capped at limit bytes. A gzip body is
+// decoded, and the cap then applies to the decoded size as well, so a
+// small upload cannot expand past it. Exceeding the cap surfaces as an
+// *http.MaxBytesError from Read.
+func limitedBody(w http.ResponseWriter, r *http.Request, limit int64) (io.ReadCloser, error) {
+	body := http.MaxBytesReader(w, r.Body, limit)
+
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, gzipError(err)
+		}
+		return http.MaxBytesReader(w, gzipBody{zr}, limit), nil
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+// gzipBody tags decoding errors with errInvalidGzip so they can be told
+// apart from errors in the decoded content.
+type gzipBody struct {
+	*gzip.Reader
+}
+
+func (b gzipBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = gzipError(err)
+	}
+	return n, err
+}
+
+func gzipError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errInvalidGzip, err)
+}
+
+// viaProxy reports whether r carries headers that proxies add on the way
+// to the server. Their absence proves nothing, since many proxies can be
+// told not to add them.
+func viaProxy(r *http.Request) bool {
+	return r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Via") != ""
+}
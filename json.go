@@ -0,0 +1,15 @@
+//go:build !jsonv2 || !go1.27
+
+package main
+
+import "encoding/json"
+
+// jsonMarshal and jsonUnmarshal are the only entry points to the JSON
+// implementation. Building with -tags jsonv2 swaps in encoding/json/v2.
+func jsonMarshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func jsonUnmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
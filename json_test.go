@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// broadcastEnvelope is shaped like the envelope TriggerService sends.
+func broadcastEnvelope() map[string]any {
+	return map[string]any{
+		"event_id":   "0b6d2d4e-6f0e-4a53-9a39-54c4f5a3b1a7",
+		"channel":    "notifications",
+		"event_type": "notification",
+		"timestamp":  int64(1704110400),
+		"payload":    json.RawMessage(`{"text":"Your order shipped","order":{"id":1234,"items":[1,2,3]},"read":false}`),
+		"trace_context": map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	}
+}
+
+// TestJSONRoundTrip checks that whichever implementation the build uses
+// decodes what it encodes into the same values encoding/json would.
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"broadcast envelope", broadcastEnvelope()},
+		{"html characters", map[string]string{"text": "<b>Tom & Jerry</b>"}},
+		{"unicode", map[string]string{"text": "héllo   世界"}},
+		{"claims", &Claims{UserID: 7, Role: roleAdmin, Extra: map[string]json.RawMessage{"plan": json.RawMessage(`"pro"`)}}},
+		{"nested arrays", []any{1, "two", []any{3.5, nil, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := jsonMarshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got any
+			if err := jsonUnmarshal(data, &got); err != nil {
+				t.Fatalf("decoding %s: %v", data, err)
+			}
+
+			std, err := json.Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want any
+			if err := json.Unmarshal(std, &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+// TestJSONGoesThroughWrapper makes sure only json.go and json_v2.go
+// marshal or unmarshal, so the jsonv2 tag switches every call.
+func TestJSONGoesThroughWrapper(t *testing.T) {
+	banned := map[string]bool{
+		"Marshal": true, "MarshalIndent": true, "Unmarshal": true,
+		"NewEncoder": true, "NewDecoder": true,
+	}
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || name == "json.go" || name == "json_v2.go" {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", name, err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "json" && banned[sel.Sel.Name] {
+				t.Errorf("%s calls json.%s; use jsonMarshal or jsonUnmarshal", fset.Position(sel.Pos()), sel.Sel.Name)
+			}
+			return true
+		})
+	}
+}
+
+// The benchmarks measure whichever implementation the build selects;
+// run them with and without -tags jsonv2 to compare.
+
+func BenchmarkJSONMarshal(b *testing.B) {
+	v := broadcastEnvelope()
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := jsonMarshal(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJSONUnmarshal(b *testing.B) {
+	data, err := jsonMarshal(broadcastEnvelope())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		var v map[string]any
+		if err := jsonUnmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
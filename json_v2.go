@@ -0,0 +1,16 @@
+//go:build jsonv2 && go1.27
+
+package main
+
+import "encoding/json/v2"
+
+// With -tags jsonv2 the service marshals with encoding/json/v2, which
+// skips HTML escaping and matches field names case-sensitively. The
+// package is only used from Go 1.27 on; older toolchains ignore the tag.
+func jsonMarshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func jsonUnmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
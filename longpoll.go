@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	transportLongPoll       = "long_poll"
+	defaultLongPollTimeout  = 30 * time.Second
+	maxLongPollTimeout      = 60 * time.Second
+	longPollShutdownRetryIn = "5"
+)
+
+// longPollHandler serves GET /events?transport=long_poll for clients
+// behind proxies that block text/event-stream. It registers like an SSE
+// client, answers with the first event to arrive as a JSON object and
+// returns, or answers 204 after ?timeout= seconds (default 30, at most
+// 60). With ?since_event_id= an event stored after that one is returned
+// at once, so nothing published between two polls is missed.
+func (s *Server) longPollHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	channel := q.Get("channel")
+	if channel == "" {
+		channel = defaultChannel
+	}
+	if verr := s.validateChannelName(channel); verr != nil {
+		http.Error(w, verr.Detail, http.StatusBadRequest)
+		return
+	}
+
+	timeout := defaultLongPollTimeout
+	if v := q.Get("timeout"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			http.Error(w, "timeout must be a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+		timeout = min(time.Duration(secs)*time.Second, maxLongPollTimeout)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	messageChan := make(chan *Frame, 10)
+	meta := &ClientMeta{
+		ConnID:      uuid.NewString(),
+		SessionID:   uuid.NewString(),
+		Channel:     channel,
+		Format:      transportLongPoll,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: s.clock.Now(),
+	}
+	s.identifyClient(r, meta)
+
+	// Register before looking at history, so an event published while the
+	// query runs is caught by one or the other.
+	s.clients.Add(messageChan, meta)
+	defer s.clients.Remove(messageChan)
+
+	if since := q.Get("since_event_id"); since != "" {
+		frame, err := s.eventAfter(r.Context(), channel, since)
+		if err != nil {
+			meta.Logger.Error("Failed to look up missed events", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if frame != nil {
+			writeLongPollFrame(w, frame)
+			return
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case frame := <-messageChan:
+		writeLongPollFrame(w, frame)
+	case <-timer.C:
+		w.WriteHeader(http.StatusNoContent)
+	case <-s.sseClosed:
+		w.Header().Set("Retry-After", longPollShutdownRetryIn)
+		http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
+
+// writeLongPollFrame answers a poll with frame in the NDJSON encoding.
+func writeLongPollFrame(w http.ResponseWriter, frame *Frame) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	frame.WriteNDJSON(w)
+}
+
+// eventAfter returns the first stored event of channel after the one
+// with eventID, or nil when there is none. An unknown eventID matches
+// nothing rather than replaying the whole history.
+func (s *Server) eventAfter(ctx context.Context, channel, eventID string) (*Frame, error) {
+	events, err := s.store.GetSince(ctx, channel, eventID, 1)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	e := events[0]
+	return eventFrame(e.EventID, e.EventType, e.Message), nil
+}
@@ -1,225 +1,122 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"log/slog"
-	"net/http"
+	"net"
 	"os"
-	"strings"
-	"sync"
-	"time"
+	"os/signal"
+	"runtime"
+	"syscall"
 
-	"github.com/golang-jwt/jwt/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
-type Config struct {
-	Port        string
-	JwtSecret   []byte
-	DatabaseURL string
-}
-
-type Server struct {
-	db        *sql.DB
-	config    Config
-	clients   map[chan []byte]bool
-	clientsMu sync.RWMutex
-	logger    *slog.Logger
-}
-
-type Claims struct {
-	UserID uint `json:"user_id"`
-	jwt.RegisteredClaims
-}
-
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-
-	cfg := loadConfig()
+	logger := slog.New(newTracelogHandler(slog.NewJSONHandler(os.Stdout, nil)))
+
+	// The runtime has already derived this from the container's CPU quota
+	// unless the binary was built with -tags nomaxprocs.
+	logger.Info("GOMAXPROCS set", "gomaxprocs", runtime.GOMAXPROCS(0), "num_cpu", runtime.NumCPU())
+
+	// secret:// values are resolved first so every read in loadConfig sees
+	// the secret rather than the reference.
+	secretsErr := resolveEnvSecrets(context.Background())
+	cfg, err := loadConfig(getenv)
+	if err = errors.Join(secretsErr, err); err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if cfg.BroadcastSendTimeout > 0 && cfg.BackpressureStrategy != BackpressureBlock {
+		logger.Warn("BROADCAST_SEND_TIMEOUT_MS is set; each slow client can delay a broadcast by that long",
+			"timeout_ms", cfg.BroadcastSendTimeout.Milliseconds())
+	}
 
-	db, err := sql.Open("pgx", cfg.DatabaseURL)
+	db, err := ConnectDB(cfg.DatabaseURL, defaultPoolOptions)
 	if err != nil {
-		logger.Error("Failed to open database connection", "error", err)
+		logger.Error("Failed to connect to database", "error", err)
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	if err := db.Ping(); err != nil {
-		logger.Error("Failed to ping database", "error", err)
+	if err := runMigrations(cfg.DatabaseURL, cfg.MigrationLockTimeout, logger); err != nil {
+		logger.Error("Failed to run migrations", "error", err)
 		os.Exit(1)
 	}
 
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	opts := []Option{WithLogger(logger)}
 
-	srv := &Server{
-		db:      db,
-		config:  cfg,
-		clients: make(map[chan []byte]bool),
-		logger:  logger,
+	var replica *sql.DB
+	if cfg.ReadReplicaURL != "" {
+		replica, err = ConnectDB(cfg.ReadReplicaURL, defaultPoolOptions)
+		if err != nil {
+			logger.Error("Failed to connect to read replica", "error", err)
+			os.Exit(1)
+		}
+		defer replica.Close()
+		opts = append(opts, WithReadReplica(replica))
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/events", srv.sseHandler)
-	mux.HandleFunc("/trigger", srv.authMiddleware(srv.triggerHandler))
-
-	logger.Info("Server starting", "port", cfg.Port)
-	server := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: mux,
-	}
+	srv := NewServer(db, cfg, opts...)
 
-	if err := server.ListenAndServe(); err != nil {
-		logger.Error("Server failed", "error", err)
+	listener, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		logger.Error("Failed to listen", "port", cfg.Port, "error", err)
 		os.Exit(1)
 	}
-}
+	logger.Info("Server starting", "addr", listener.Addr().String())
 
-func loadConfig() Config {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		slog.Warn("JWT_SECRET is not set")
+	if err := srv.loadEventTypes(ctx); err != nil {
+		logger.Warn("Failed to load event types, accepting all", "error", err)
 	}
+	go srv.reloadEvery(cfg.EventTypeReloadInterval, "event types", srv.loadEventTypes)
 
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		slog.Warn("DATABASE_URL is not set")
+	if err := srv.loadRoutingRules(ctx); err != nil {
+		logger.Warn("Failed to load routing rules", "error", err)
 	}
+	go srv.reloadEvery(cfg.RoutingReloadInterval, "routing rules", srv.loadRoutingRules)
 
-	return Config{
-		Port:        port,
-		JwtSecret:   []byte(secret),
-		DatabaseURL: dbURL,
+	if err := srv.channels.Refresh(ctx); err != nil {
+		logger.Warn("Failed to load channels", "error", err)
 	}
-}
-
-func (s *Server) triggerHandler(w http.ResponseWriter, r *http.Request) {
-	payload := map[string]any{"number": 1, "timestamp": time.Now().Unix()}
-	msg, err := json.Marshal(payload)
-	if err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-		return
+	go srv.reloadEvery(cfg.ChannelReloadInterval, "channels", srv.channels.Refresh)
+	go srv.reloadOnSignal(syscall.SIGHUP, "channels", srv.channels.Refresh)
+
+	go srv.reportDBStats(db, mainPool, cfg.DBStatsInterval)
+	go srv.runPendingWorker()
+	go srv.runRetentionCleanup()
+	go srv.runChannelPresence()
+	go srv.runChannelCleanup()
+	go srv.dumpStacksOnSignal()
+	if replica != nil {
+		go srv.reportDBStats(replica, replicaPool, cfg.DBStatsInterval)
 	}
-
-	s.broadcast(msg)
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Triggered"))
-}
-
-func (s *Server) sseHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
-		return
+	if cfg.AuthCacheTTL > 0 {
+		go srv.listenAuthInvalidations(cfg.DatabaseURL)
 	}
-
-	messageChan := make(chan []byte, 10)
-
-	s.clientsMu.Lock()
-	s.clients[messageChan] = true
-	s.clientsMu.Unlock()
-
-	s.logger.Info("New SSE client connected")
-
-	initMsg, _ := json.Marshal(map[string]any{"status": "connected"})
-	fmt.Fprintf(w, "data: %s\n\n", initMsg)
-	flusher.Flush()
-
-	defer func() {
-		s.clientsMu.Lock()
-		delete(s.clients, messageChan)
-		s.clientsMu.Unlock()
-		close(messageChan)
-		s.logger.Info("SSE client disconnected")
-	}()
-
-	for {
-		select {
-		case msg := <-messageChan:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
-			flusher.Flush()
-		case <-r.Context().Done():
-			return
-		}
+	if cfg.PGNotifyChannel != "" {
+		go srv.listenNotifications(cfg.DatabaseURL, cfg.PGNotifyChannel)
 	}
-}
 
-func (s *Server) broadcast(msg []byte) {
-	s.clientsMu.RLock()
-	defer s.clientsMu.RUnlock()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ServeListener(listener)
+	}()
 
-	for clientChan := range s.clients {
-		select {
-		case clientChan <- msg:
-		default:
-			s.logger.Warn("Dropping message for slow client")
-		}
+	select {
+	case err := <-serveErr:
+		logger.Error("Server failed", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
 	}
-}
-
-func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
-			return
-		}
-
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
-			return
-		}
-		tokenString := parts[1]
-
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-			return s.config.JwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			s.logger.Warn("Invalid token attempt", "error", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		if claims.UserID == 0 {
-			http.Error(w, "Invalid user claims", http.StatusUnauthorized)
-			return
-		}
-
-		var verificationStatus bool
-		err = s.db.QueryRow("SELECT verification_status FROM users WHERE id = $1", claims.UserID).Scan(&verificationStatus)
-
-		if err != nil {
-			if err == sql.ErrNoRows {
-				http.Error(w, "User not found", http.StatusUnauthorized)
-			} else {
-				s.logger.Error("Database query error", "error", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			}
-			return
-		}
-
-		if verificationStatus {
-			http.Error(w, "Already Requested", http.StatusConflict)
-			return
-		}
 
-		next(w, r)
+	if err := srv.Shutdown(); err != nil {
+		logger.Error("Graceful shutdown incomplete", "error", err)
 	}
+	logger.Info("Server stopped")
 }
@@ -0,0 +1,10 @@
+//go:build nomaxprocs
+
+//go:debug containermaxprocs=0
+//go:debug updatemaxprocs=0
+
+// Since Go 1.25 the runtime sets GOMAXPROCS from the container's CPU
+// quota and follows changes to it. Building with -tags nomaxprocs turns
+// that off so bare-metal deployments keep one P per host CPU.
+
+package main
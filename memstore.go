@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryMessageStore is a MessageStore that keeps events in memory, for
+// tests and single-instance deployments that need no durable history.
+type MemoryMessageStore struct {
+	mu        sync.Mutex
+	events    []Event
+	ids       map[string]bool
+	delivered map[string]time.Time
+}
+
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{ids: make(map[string]bool), delivered: make(map[string]time.Time)}
+}
+
+var errDuplicateEvent = errors.New("event already saved")
+
+func (m *MemoryMessageStore) Save(_ context.Context, e Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ids[e.EventID] {
+		return errDuplicateEvent
+	}
+	m.ids[e.EventID] = true
+	e.Message = slices.Clone(e.Message)
+	e.CreatedAt = time.Now()
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *MemoryMessageStore) GetSince(_ context.Context, channel, afterID string, limit int) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := 0
+	if afterID != "" {
+		i := slices.IndexFunc(m.events, func(e Event) bool { return e.EventID == afterID })
+		if i < 0 {
+			return nil, nil
+		}
+		start = i + 1
+	}
+
+	var events []Event
+	for _, e := range m.events[start:] {
+		if len(events) == limit {
+			break
+		}
+		if e.Channel == channel {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (m *MemoryMessageStore) Get(_ context.Context, id string) (Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.events, func(e Event) bool { return e.EventID == id })
+	if i < 0 {
+		return Event{}, ErrEventNotFound
+	}
+	e := m.events[i]
+	e.DeliveredAt = m.delivered[id]
+	return e, nil
+}
+
+// LastID numbers events in the order they were saved, starting at 1,
+// like the events table's id column.
+func (m *MemoryMessageStore) LastID(_ context.Context, channel string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, e := range slices.Backward(m.events) {
+		if e.Channel == channel {
+			return int64(i) + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryMessageStore) MarkDelivered(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.delivered[id]; !ok {
+		m.delivered[id] = time.Now()
+	}
+	return nil
+}
+
+// Delivered reports whether MarkDelivered has been called for id.
+func (m *MemoryMessageStore) Delivered(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.delivered[id]
+	return ok
+}
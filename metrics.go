@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	mainPool    = "main"
+	replicaPool = "replica"
+)
+
+type serverMetrics struct {
+	dbPoolUtilization  *prometheus.GaugeVec
+	dbPoolWaitDuration *prometheus.SummaryVec
+	messagesDelivered  prometheus.Counter
+	messagesDropped    prometheus.Counter
+	sseRTT             prometheus.Histogram
+}
+
+func newServerMetrics(reg prometheus.Registerer) *serverMetrics {
+	m := &serverMetrics{
+		dbPoolUtilization: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "peeple_db_pool_utilization_ratio",
+			Help: "Connections in use divided by the pool's maximum open connections.",
+		}, []string{"pool"}),
+		dbPoolWaitDuration: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "peeple_db_pool_wait_duration_seconds",
+			Help:       "Time spent waiting for a connection, sampled per stats interval.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, []string{"pool"}),
+		messagesDelivered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "peeple_messages_delivered_total",
+			Help: "Messages queued to SSE clients.",
+		}),
+		messagesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "peeple_messages_dropped_total",
+			Help: "Messages dropped because a client's buffer was full.",
+		}),
+		sseRTT: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "peeple_sse_rtt_seconds",
+			Help:    "Time from sending a ping event to receiving its /pong.",
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}),
+	}
+
+	reg.MustRegister(m.dbPoolUtilization, m.dbPoolWaitDuration, m.messagesDelivered, m.messagesDropped, m.sseRTT)
+	return m
+}
+
+func (s *Server) metricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{})
+}
+
+// reportDBStats logs the pool statistics of db every interval and updates
+// the pool metrics under the given pool label.
+func (s *Server) reportDBStats(db *sql.DB, pool string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastWait time.Duration
+	for {
+		select {
+		case <-ticker.C:
+			stats := db.Stats()
+
+			ratio := 0.0
+			if stats.MaxOpenConnections > 0 {
+				ratio = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+			}
+			s.metrics.dbPoolUtilization.WithLabelValues(pool).Set(ratio)
+			s.metrics.dbPoolWaitDuration.WithLabelValues(pool).Observe((stats.WaitDuration - lastWait).Seconds())
+			lastWait = stats.WaitDuration
+
+			s.logger.Debug("Database pool stats",
+				"pool", pool,
+				"open", stats.OpenConnections,
+				"in_use", stats.InUse,
+				"idle", stats.Idle,
+				"wait_count", stats.WaitCount,
+				"wait_duration", stats.WaitDuration.String())
+		case <-s.Done():
+			return
+		}
+	}
+}
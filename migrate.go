@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed sql/migrations/*.sql
+var migrationsFS embed.FS
+
+// runMigrations applies pending migrations from the embedded files. It
+// uses its own connection pool because closing the migrate instance
+// closes the database handle it was given.
+//
+// Instances starting together are serialized by golang-migrate, which
+// holds a PostgreSQL advisory lock while it migrates; the others wait up
+// to lockTimeout for it and then find nothing left to do. Only up
+// migrations are ever run. A database already migrated past the newest
+// embedded file, as after rolling back to an older binary, is left as it
+// is, since migrations are written to stay compatible with the previous
+// release.
+func runMigrations(dsn string, lockTimeout time.Duration, logger *slog.Logger) error {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return fmt.Errorf("open migration connection: %w", err)
+	}
+
+	src, err := iofs.New(migrationsFS, "sql/migrations")
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("load embedded migrations: %w", err)
+	}
+
+	driver, err := pgxmigrate.WithInstance(db, &pgxmigrate.Config{})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("create migration driver: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "pgx5", driver)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("create migrator: %w", err)
+	}
+	defer m.Close()
+	m.LockTimeout = lockTimeout
+
+	current, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if err == nil {
+		up, _, err := src.ReadUp(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("Database schema is newer than this binary, skipping migrations", "version", current)
+			return nil
+		}
+		if err == nil {
+			up.Close()
+		}
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("apply migrations: %w", err)
+	}
+	return nil
+}
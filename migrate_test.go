@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/google/uuid"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	entries, err := fs.ReadDir(migrationsFS, "sql/migrations")
+	if err != nil {
+		t.Fatalf("reading embedded migrations: %v", err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	for _, e := range entries {
+		data, err := fs.ReadFile(migrationsFS, "sql/migrations/"+e.Name())
+		if err != nil {
+			t.Errorf("%s: %v", e.Name(), err)
+			continue
+		}
+		if len(strings.TrimSpace(string(data))) == 0 {
+			t.Errorf("%s is empty", e.Name())
+		}
+	}
+
+	src, err := iofs.New(migrationsFS, "sql/migrations")
+	if err != nil {
+		t.Fatalf("iofs.New: %v", err)
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	for want := uint(1); err == nil; want++ {
+		if version != want {
+			t.Fatalf("migration %d follows %d; versions must be consecutive", version, want-1)
+		}
+		if up, _, rerr := src.ReadUp(version); rerr != nil {
+			t.Errorf("migration %d has no up file: %v", version, rerr)
+		} else {
+			up.Close()
+		}
+		if down, _, rerr := src.ReadDown(version); rerr != nil {
+			t.Errorf("migration %d has no down file: %v", version, rerr)
+		} else {
+			down.Close()
+		}
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("listing migrations: %v", err)
+	}
+}
+
+// TestConcurrentMigrations starts two migration runners at once against
+// a fresh schema, as two instances deploying together would. It needs a
+// PostgreSQL database and runs only when TEST_DATABASE_URL points at one;
+// everything it creates lives in a throwaway schema.
+func TestConcurrentMigrations(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	schema := "migrate_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DROP SCHEMA " + schema + " CASCADE") })
+	// users comes from the accounts service; migration 000010 needs it.
+	if _, err := db.Exec("CREATE TABLE " + schema + ".users (id BIGINT PRIMARY KEY, verification_status BOOLEAN)"); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	// Were the runners not serialized, the loser would fail creating
+	// tables the winner had just created.
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() { errs <- runMigrations(u.String(), 30*time.Second, slog.New(slog.DiscardHandler)) }()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Errorf("runMigrations: %v", err)
+		}
+	}
+
+	src, err := iofs.New(migrationsFS, "sql/migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	latest, err := src.First()
+	for next := latest; err == nil; next, err = src.Next(next) {
+		latest = next
+	}
+
+	var (
+		version int64
+		dirty   bool
+	)
+	if err := db.QueryRow("SELECT version, dirty FROM "+schema+".schema_migrations").Scan(&version, &dirty); err != nil {
+		t.Fatal(err)
+	}
+	if version != int64(latest) || dirty {
+		t.Errorf("schema at version %d, dirty %v; want %d, clean", version, dirty, latest)
+	}
+}
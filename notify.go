@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const notifyReconnectDelay = 5 * time.Second
+
+// notificationHandler processes one NOTIFY payload.
+type notificationHandler func(ctx context.Context, payload []byte) error
+
+// listenNotifications broadcasts every payload sent with NOTIFY on channel
+// until the server shuts down, reconnecting after connection failures.
+func (s *Server) listenNotifications(dsn, channel string) {
+	s.listen(dsn, channel, nil, s.handleNotification)
+}
+
+// listen passes every NOTIFY payload on channel to handle until the server
+// shuts down, reconnecting after connection failures. onConnect, if set,
+// runs once LISTEN succeeds on each new connection.
+func (s *Server) listen(dsn, channel string, onConnect func(), handle notificationHandler) {
+	for {
+		err := s.listenOnce(s.ctx, dsn, channel, onConnect, handle)
+		if s.ctx.Err() != nil {
+			return
+		}
+		s.logger.Error("Notification listener stopped, reconnecting", "error", err, "delay", notifyReconnectDelay.String())
+
+		select {
+		case <-time.After(notifyReconnectDelay):
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) listenOnce(ctx context.Context, dsn, channel string, onConnect func(), handle notificationHandler) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	s.logger.Info("Listening for notifications", "pg_channel", channel)
+	if onConnect != nil {
+		onConnect()
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if err := handle(ctx, []byte(n.Payload)); err != nil {
+			return err
+		}
+	}
+}
+
+// handleNotification fans out one NOTIFY payload. Every instance receives
+// the notification, so each first tries a transaction-scoped advisory
+// lock keyed on a hash of the payload: the instance that gets it
+// broadcasts and the others skip the payload. The lock is released when
+// the transaction ends after fan-out. If the lock cannot be asked for at
+// all, the payload is broadcast anyway, since a duplicate is better than
+// a lost event.
+func (s *Server) handleNotification(ctx context.Context, payload []byte) error {
+	var envelope struct {
+		EventID   string `json:"event_id"`
+		Channel   string `json:"channel"`
+		EventType string `json:"event_type"`
+	}
+	jsonUnmarshal(payload, &envelope)
+	if envelope.EventType == "" {
+		envelope.EventType = "notify"
+	}
+	if !validEventType(envelope.EventType) {
+		// The type would become the frame's event: line; see
+		// eventTypePattern.
+		s.logger.Warn("Skipping notification with invalid event type",
+			"event_id", envelope.EventID, "event_type", envelope.EventType)
+		return nil
+	}
+	if envelope.Channel == "" {
+		envelope.Channel = defaultChannel
+	}
+
+	key := notificationLockKey(payload)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		var acquired bool
+		err = tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", key).Scan(&acquired)
+		if err == nil && !acquired {
+			s.logger.Debug("Notification handled by another instance", "lock_key", key)
+			return nil
+		}
+	}
+	if err != nil {
+		s.logger.Error("Failed to take notification lock, broadcasting anyway", "lock_key", key, "error", err)
+	}
+
+	if _, _, err := s.broadcastToChannel(ctx, envelope.Channel, eventFrame(envelope.EventID, envelope.EventType, payload)); err != nil {
+		s.logger.Warn("Notification partially delivered", "error", err)
+	}
+	if tx != nil {
+		tx.Commit()
+	}
+	return nil
+}
+
+// notificationLockKey is the advisory lock key for a NOTIFY payload.
+func notificationLockKey(payload []byte) int64 {
+	h := fnv.New64a()
+	h.Write(payload)
+	return int64(h.Sum64())
+}
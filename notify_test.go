@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// grantLock answers pg_try_advisory_xact_lock with acquired.
+func grantLock(acquired bool) func(q fakeQuery) fakeResult {
+	return func(q fakeQuery) fakeResult {
+		if strings.Contains(q.SQL, "pg_try_advisory_xact_lock") {
+			return fakeRow(acquired)
+		}
+		return unverifiedUsers(q)
+	}
+}
+
+func TestHandleNotification(t *testing.T) {
+	payload := []byte(`{"event_id":"a","channel":"orders","event_type":"order_shipped"}`)
+	tests := []struct {
+		name    string
+		handle  func(q fakeQuery) fakeResult
+		wantOut bool
+	}{
+		{name: "lock acquired", handle: grantLock(true), wantOut: true},
+		{name: "lock held by another instance", handle: grantLock(false)},
+		{name: "lock unavailable", handle: func(q fakeQuery) fakeResult {
+			return fakeResult{Err: errors.New("connection refused")}
+		}, wantOut: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, _ := newTestServer(t, testConfig())
+			fake.setHandler(tt.handle)
+			ch := addClients(s, 1, "orders", 1)[0]
+
+			if err := s.handleNotification(context.Background(), payload); err != nil {
+				t.Fatal(err)
+			}
+			for _, q := range fake.Queries() {
+				if strings.Contains(q.SQL, "pg_try_advisory_xact_lock") &&
+					(len(q.Args) != 1 || q.Args[0] != notificationLockKey(payload)) {
+					t.Errorf("lock query args = %v, want [%d]", q.Args, notificationLockKey(payload))
+				}
+			}
+			if fake.countQueries("pg_try_advisory_xact_lock") != 1 {
+				t.Errorf("lock asked for %d times, want once", fake.countQueries("pg_try_advisory_xact_lock"))
+			}
+
+			select {
+			case f := <-ch:
+				if !tt.wantOut {
+					t.Fatalf("broadcast %+v without the lock", f)
+				}
+				if f.ID != "a" || f.Event != "order_shipped" {
+					t.Errorf("frame id %q event %q, want a and order_shipped", f.ID, f.Event)
+				}
+			default:
+				if tt.wantOut {
+					t.Fatal("nothing broadcast")
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationLockKey(t *testing.T) {
+	a, b := []byte(`{"n":1}`), []byte(`{"n":2}`)
+	if notificationLockKey(a) != notificationLockKey([]byte(`{"n":1}`)) {
+		t.Error("equal payloads get different keys")
+	}
+	if notificationLockKey(a) == notificationLockKey(b) {
+		t.Error("different payloads share a key")
+	}
+}
+
+func TestHandleNotificationEventType(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		wantEvent string // empty when nothing may be sent
+	}{
+		{"named", `{"event_type":"order_shipped"}`, "order_shipped"},
+		{"missing", `{"n":1}`, "notify"},
+		{"forged frames", `{"event_id":"a","event_type":"x\ndata: {\"event\":\"logout\"}\n\nevent: close"}`, ""},
+		{"carriage return", `{"event_type":"x\revent: close"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, _ := newTestServer(t, testConfig())
+			fake.setHandler(grantLock(true))
+			ch := addClients(s, 1, defaultChannel, 1)[0]
+
+			if err := s.handleNotification(context.Background(), []byte(tt.payload)); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case f := <-ch:
+				if f.Event != tt.wantEvent {
+					t.Errorf("event = %q, want %q", f.Event, tt.wantEvent)
+				}
+			default:
+				if tt.wantEvent != "" {
+					t.Errorf("nothing sent, want event %q", tt.wantEvent)
+				}
+			}
+		})
+	}
+}
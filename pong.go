@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	pongInterval = time.Second
+	// pongMaxAge bounds the RTTs recorded, so stale or forged server_time
+	// values do not skew the histogram.
+	pongMaxAge = time.Minute
+)
+
+// pongRequest echoes the server_time of a ping event back to the server.
+type pongRequest struct {
+	ServerTime        int64 `json:"server_time"`
+	ClientReceiveTime int64 `json:"client_receive_time"`
+}
+
+// ipLimiter allows one request per interval from each IP address.
+type ipLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func (l *ipLimiter) Allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.last == nil {
+		l.last = make(map[string]time.Time)
+	}
+	if t, ok := l.last[ip]; ok && now.Sub(t) < l.interval {
+		return false
+	}
+	// Entries older than interval no longer limit anyone; drop them once
+	// the map grows so it stays bounded by the recent request rate.
+	if len(l.last) >= 10000 {
+		for k, t := range l.last {
+			if now.Sub(t) >= l.interval {
+				delete(l.last, k)
+			}
+		}
+	}
+	l.last[ip] = now
+	return true
+}
+
+// pongHandler records the round trip of a ping event: from the
+// server_time it was sent with to now. client_receive_time is only
+// logged, since client clocks cannot be trusted to match ours.
+func (s *Server) pongHandler(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	now := s.clock.Now()
+	if !s.pongs.Allow(ip, now) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+
+	var req pongRequest
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, 1<<10), &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	rtt := now.Sub(time.UnixMilli(req.ServerTime))
+	if req.ServerTime <= 0 || rtt < 0 || rtt > pongMaxAge {
+		http.Error(w, "server_time must come from a recent ping", http.StatusBadRequest)
+		return
+	}
+
+	s.metrics.sseRTT.Observe(rtt.Seconds())
+	s.logger.Debug("SSE pong", "remote_addr", r.RemoteAddr, "rtt", rtt.String(), "client_receive_time", req.ClientReceiveTime)
+	w.WriteHeader(http.StatusNoContent)
+}
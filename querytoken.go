@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// queryTokenParam carries a Bearer token for clients that cannot set
+// headers, such as the browser EventSource API.
+const queryTokenParam = "access_token"
+
+// queryTokenMiddleware accepts ?access_token=<jwt> on /events. The token
+// is moved into the Authorization header and removed from the URL before
+// anything else sees the request, so it is never logged from here on. An
+// Authorization header already on the request takes precedence.
+//
+// Query strings end up in proxy logs and browser history, so tokens are
+// only taken from them over HTTPS. A token sent over plain HTTP is
+// already exposed; the request is refused so clients notice.
+func (s *Server) queryTokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if !q.Has(queryTokenParam) {
+			next(w, r)
+			return
+		}
+
+		if !isHTTPS(r) {
+			s.logger.Warn("Rejected access token in query string over plain HTTP",
+				"path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeJSON(w, http.StatusForbidden, map[string]any{
+				"error":  "access_denied",
+				"reason": "insecure_transport",
+			})
+			return
+		}
+
+		token := q.Get(queryTokenParam)
+		q.Del(queryTokenParam)
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = q.Encode()
+		r.RequestURI = r.URL.RequestURI()
+		if r.Header.Get("Authorization") == "" && token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// isHTTPS reports whether the client connected over TLS, either to this
+// server or to a proxy in front of it that set X-Forwarded-Proto.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
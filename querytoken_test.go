@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestQueryToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// https sends X-Forwarded-Proto: https.
+		https bool
+		// header is a token of another user sent in Authorization.
+		header     bool
+		wantStatus int
+		wantUser   string
+	}{
+		{name: "over https", https: true, wantStatus: http.StatusOK, wantUser: `"user_id":7`},
+		{name: "over plain http", wantStatus: http.StatusForbidden},
+		{name: "header takes precedence", https: true, header: true, wantStatus: http.StatusOK, wantUser: `"user_id":8`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs lockedBuffer
+			logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			s, _, _ := newTestServer(t, testConfig(), WithLogger(logger))
+			ts := startServer(t, s)
+
+			token := signToken(t, &Claims{UserID: 7})
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/events?channel=room&"+queryTokenParam+"="+url.QueryEscape(token), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.https {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			}
+			if tt.header {
+				req.Header.Set("Authorization", "Bearer "+signToken(t, &Claims{UserID: 8}))
+			}
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if ev := readEvent(t, bufio.NewReader(resp.Body)); !strings.Contains(ev.Data, tt.wantUser) {
+					t.Errorf("connected event = %s, want %s", ev.Data, tt.wantUser)
+				}
+			} else if !strings.Contains(logs.String(), "Rejected access token in query string") {
+				t.Errorf("no warning logged for a token over plain HTTP: %s", logs.String())
+			}
+
+			if strings.Contains(logs.String(), token) {
+				t.Errorf("token appears in the logs: %s", logs.String())
+			}
+		})
+	}
+}
+
+// TestQueryTokenRemovedFromURL checks what the handlers after the
+// middleware see, so any access log written from there cannot include
+// the token.
+func TestQueryTokenRemovedFromURL(t *testing.T) {
+	s, _, _ := newTestServer(t, testConfig())
+
+	var got *http.Request
+	h := s.queryTokenMiddleware(func(w http.ResponseWriter, r *http.Request) { got = r })
+	r := httptest.NewRequest(http.MethodGet, "/events?channel=room&access_token=secret-token", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	h(httptest.NewRecorder(), r)
+
+	if got == nil {
+		t.Fatal("handler not called")
+	}
+	for name, v := range map[string]string{"RawQuery": got.URL.RawQuery, "RequestURI": got.RequestURI, "URL": got.URL.String()} {
+		if strings.Contains(v, "secret-token") {
+			t.Errorf("%s = %q still carries the token", name, v)
+		}
+	}
+	if got.URL.Query().Get("channel") != "room" {
+		t.Errorf("other parameters lost: %q", got.URL.RawQuery)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer secret-token" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if r.URL.Query().Get(queryTokenParam) == "" {
+		t.Error("middleware modified the caller's request")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate limit algorithms selectable with RATE_LIMIT_ALGORITHM.
+const (
+	rateLimitFixedWindow   = "fixed_window"
+	rateLimitSlidingWindow = "sliding_window"
+)
+
+// rateLimiter decides whether the request identified by key may proceed.
+type rateLimiter interface {
+	Allow(ctx context.Context, key string, now time.Time) rateDecision
+	Limit() int
+}
+
+// idleEvicter is implemented by limiters that keep state per key. The
+// channel cleanup tick calls evictIdle to drop keys whose state has
+// gone back to what a new key would start with.
+type idleEvicter interface {
+	evictIdle(now time.Time)
+}
+
+// newTriggerLimiter builds the limiter for TRIGGER_RATE_LIMIT, or returns
+// nil when the limit is off.
+func newTriggerLimiter(cfg Config, logger *slog.Logger) rateLimiter {
+	if cfg.TriggerRateLimit <= 0 {
+		return nil
+	}
+	if cfg.RateLimitAlgorithm != rateLimitSlidingWindow {
+		return &windowLimiter{limit: cfg.TriggerRateLimit, window: cfg.TriggerRateWindow}
+	}
+	if cfg.RedisURL != "" {
+		limiter, err := newRedisLimiter(cfg.RedisURL, cfg.TriggerRateLimit, cfg.TriggerRateWindow, logger)
+		if err == nil {
+			return limiter
+		}
+		logger.Warn("Invalid REDIS_URL, using in-memory sliding window", "error", err)
+	}
+	return &slidingLimiter{limit: cfg.TriggerRateLimit, window: cfg.TriggerRateWindow}
+}
+
+// windowLimiter allows limit requests per key in each fixed window.
+type windowLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	entries map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateDecision is the limiter's state for one request, as reported in
+// the X-RateLimit-* headers.
+type rateDecision struct {
+	allowed   bool
+	remaining int
+	reset     time.Time
+}
+
+func (l *windowLimiter) Limit() int { return l.limit }
+
+func (l *windowLimiter) Allow(_ context.Context, key string, now time.Time) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.entries == nil {
+		l.entries = make(map[string]*rateWindow)
+	}
+	w, ok := l.entries[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		if len(l.entries) >= 10000 {
+			for k, old := range l.entries {
+				if now.Sub(old.start) >= l.window {
+					delete(l.entries, k)
+				}
+			}
+		}
+		w = &rateWindow{start: now}
+		l.entries[key] = w
+	}
+
+	d := rateDecision{reset: w.start.Add(l.window)}
+	if w.count >= l.limit {
+		return d
+	}
+	w.count++
+	d.allowed = true
+	d.remaining = l.limit - w.count
+	return d
+}
+
+func (l *windowLimiter) evictIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for k, w := range l.entries {
+		if now.Sub(w.start) >= l.window {
+			delete(l.entries, k)
+		}
+	}
+}
+
+// slidingLimiter allows limit requests per key in any window-long span,
+// keeping the time of each key's last limit requests in a ring.
+type slidingLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	entries map[string]*requestRing
+}
+
+// requestRing holds up to len(times) request times; next is both where
+// the next one goes and, once full, the oldest.
+type requestRing struct {
+	times []time.Time
+	next  int
+	n     int
+}
+
+func (r *requestRing) oldest() time.Time {
+	if r.n < len(r.times) {
+		return r.times[0]
+	}
+	return r.times[r.next]
+}
+
+// oldestAfter returns the earliest stored request made after cutoff.
+func (r *requestRing) oldestAfter(cutoff time.Time) time.Time {
+	var oldest time.Time
+	for _, t := range r.times[:r.n] {
+		if t.After(cutoff) && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
+// inWindow counts the stored requests made after cutoff.
+func (r *requestRing) inWindow(cutoff time.Time) int {
+	count := 0
+	for _, t := range r.times[:r.n] {
+		if t.After(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
+func (l *slidingLimiter) Limit() int { return l.limit }
+
+func (l *slidingLimiter) Allow(_ context.Context, key string, now time.Time) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.entries == nil {
+		l.entries = make(map[string]*requestRing)
+	}
+	cutoff := now.Add(-l.window)
+	ring, ok := l.entries[key]
+	if !ok {
+		if len(l.entries) >= 10000 {
+			for k, old := range l.entries {
+				if old.inWindow(cutoff) == 0 {
+					delete(l.entries, k)
+				}
+			}
+		}
+		ring = &requestRing{times: make([]time.Time, l.limit)}
+		l.entries[key] = ring
+	}
+
+	if ring.n == len(ring.times) && ring.oldest().After(cutoff) {
+		return rateDecision{reset: ring.oldest().Add(l.window)}
+	}
+
+	ring.times[ring.next] = now
+	ring.next = (ring.next + 1) % len(ring.times)
+	ring.n = min(ring.n+1, len(ring.times))
+	return rateDecision{
+		allowed:   true,
+		remaining: l.limit - ring.inWindow(cutoff),
+		// Requests that already left the window may still be in the ring,
+		// so its oldest entry is not necessarily the next to expire.
+		reset: ring.oldestAfter(cutoff).Add(l.window),
+	}
+}
+
+func (l *slidingLimiter) evictIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-l.window)
+	for k, ring := range l.entries {
+		if ring.inWindow(cutoff) == 0 {
+			delete(l.entries, k)
+		}
+	}
+}
+
+// bucketLimiter is a token bucket per key holding limit tokens and
+// refilling them over window. redisLimiter falls back to it while Redis
+// is unreachable.
+type bucketLimiter struct {
+	limit   int
+	window  time.Duration
+	buckets sync.Map // key -> *tokenBucket
+}
+
+func (l *bucketLimiter) Limit() int { return l.limit }
+
+func (l *bucketLimiter) Allow(_ context.Context, key string, now time.Time) rateDecision {
+	v, ok := l.buckets.Load(key)
+	if !ok {
+		v, _ = l.buckets.LoadOrStore(key, &tokenBucket{
+			rate:   float64(l.limit) / l.window.Seconds(),
+			burst:  float64(l.limit),
+			tokens: float64(l.limit),
+			last:   now,
+		})
+	}
+	b := v.(*tokenBucket)
+
+	allowed := b.Allow(now)
+	b.mu.Lock()
+	tokens := b.tokens
+	b.mu.Unlock()
+
+	// Reset is when the bucket will be full again.
+	refill := time.Duration((b.burst - tokens) / b.rate * float64(time.Second))
+	return rateDecision{allowed: allowed, remaining: int(tokens), reset: now.Add(refill)}
+}
+
+func (l *bucketLimiter) evictIdle(now time.Time) {
+	l.buckets.Range(func(k, v any) bool {
+		if v.(*tokenBucket).idle(now) {
+			l.buckets.CompareAndDelete(k, v)
+		}
+		return true
+	})
+}
+
+// triggerRateLimit limits each user to TRIGGER_RATE_LIMIT requests per
+// TRIGGER_RATE_WINDOW and reports the state in X-RateLimit-* headers on
+// every response, using the algorithm chosen by RATE_LIMIT_ALGORITHM.
+// It must run after authMiddleware. Every route wrapped
+// with it shares one limiter, so the limit is per user across all of
+// them. A limit of 0 turns it off.
+func (s *Server) triggerRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	limiter := s.triggerLimiter
+	if limiter == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		now := s.clock.Now()
+		d := limiter.Allow(r.Context(), strconv.FormatUint(claims.EffectiveUserID(), 10), now)
+
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(limiter.Limit()))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(d.remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(d.reset.Unix(), 10))
+
+		if !d.allowed {
+			retry := int(d.reset.Sub(now).Round(time.Second).Seconds())
+			h.Set("Retry-After", strconv.Itoa(max(retry, 1)))
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{"error": "rate_limited"})
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix  = "peeple:ratelimit:"
+	redisOpTimeout  = 100 * time.Millisecond
+	redisWarnPeriod = time.Minute
+)
+
+// redisLimiter is a sliding window log in a Redis sorted set per key, so
+// the limit holds across instances. While Redis fails, requests are
+// limited per instance by an in-memory token bucket instead.
+type redisLimiter struct {
+	client   *redis.Client
+	limit    int
+	window   time.Duration
+	fallback *bucketLimiter
+	logger   *slog.Logger
+	seq      atomic.Int64
+	lastWarn atomic.Int64 // Unix nanoseconds
+}
+
+func newRedisLimiter(url string, limit int, window time.Duration, logger *slog.Logger) (*redisLimiter, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisLimiter{
+		client:   redis.NewClient(opts),
+		limit:    limit,
+		window:   window,
+		fallback: &bucketLimiter{limit: limit, window: window},
+		logger:   logger,
+	}, nil
+}
+
+func (l *redisLimiter) Limit() int { return l.limit }
+
+// evictIdle drops idle fallback buckets; the Redis keys expire on their
+// own.
+func (l *redisLimiter) evictIdle(now time.Time) { l.fallback.evictIdle(now) }
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, now time.Time) rateDecision {
+	d, err := l.allow(ctx, redisKeyPrefix+key, now)
+	if err != nil {
+		if last := l.lastWarn.Load(); now.UnixNano()-last > int64(redisWarnPeriod) && l.lastWarn.CompareAndSwap(last, now.UnixNano()) {
+			l.logger.Warn("Redis rate limiter unavailable, using in-memory token bucket", "error", err)
+		}
+		return l.fallback.Allow(ctx, key, now)
+	}
+	return d
+}
+
+// allow records the request in the key's log and removes it again when
+// it takes the count over the limit, so rejected requests do not extend
+// the window.
+func (l *redisLimiter) allow(ctx context.Context, key string, now time.Time) (rateDecision, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	nowMs := now.UnixMilli()
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.FormatInt(l.seq.Add(1), 10)
+
+	var count *redis.IntCmd
+	var oldest *redis.ZSliceCmd
+	_, err := l.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(nowMs-l.window.Milliseconds(), 10))
+		p.ZAdd(ctx, key, redis.Z{Score: float64(nowMs), Member: member})
+		count = p.ZCard(ctx, key)
+		oldest = p.ZRangeWithScores(ctx, key, 0, 0)
+		p.PExpire(ctx, key, l.window)
+		return nil
+	})
+	if err != nil {
+		return rateDecision{}, err
+	}
+
+	reset := now.Add(l.window)
+	if z := oldest.Val(); len(z) > 0 {
+		reset = time.UnixMilli(int64(z[0].Score)).Add(l.window)
+	}
+
+	n := int(count.Val())
+	if n > l.limit {
+		if err := l.client.ZRem(ctx, key, member).Err(); err != nil {
+			return rateDecision{}, err
+		}
+		return rateDecision{reset: reset}, nil
+	}
+	return rateDecision{allowed: true, remaining: l.limit - n, reset: reset}, nil
+}
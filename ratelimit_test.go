@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTriggerRateLimitHeaders(t *testing.T) {
+	type step struct {
+		advance       time.Duration
+		userID        uint64
+		wantStatus    int
+		wantRemaining int
+		// wantReset is the X-RateLimit-Reset wanted, as an offset from the
+		// start of the test.
+		wantReset time.Duration
+		// wantRetry is the Retry-After wanted in seconds, 0 for none.
+		wantRetry int
+	}
+	allowed := func(remaining int) step {
+		return step{userID: 1, wantStatus: http.StatusOK, wantRemaining: remaining, wantReset: time.Minute}
+	}
+
+	tests := []struct {
+		algorithm string
+		steps     []step
+	}{
+		{
+			algorithm: rateLimitFixedWindow,
+			steps: []step{
+				allowed(2), allowed(1), allowed(0),
+				{userID: 1, wantStatus: http.StatusTooManyRequests, wantReset: time.Minute, wantRetry: 60},
+				{userID: 2, wantStatus: http.StatusOK, wantRemaining: 2, wantReset: time.Minute},
+				{advance: 30 * time.Second, userID: 1, wantStatus: http.StatusTooManyRequests, wantReset: time.Minute, wantRetry: 30},
+				{advance: 30 * time.Second, userID: 1, wantStatus: http.StatusOK, wantRemaining: 2, wantReset: 2 * time.Minute},
+			},
+		},
+		{
+			algorithm: rateLimitSlidingWindow,
+			steps: []step{
+				allowed(2), allowed(1), allowed(0),
+				{userID: 1, wantStatus: http.StatusTooManyRequests, wantReset: time.Minute, wantRetry: 60},
+				{userID: 2, wantStatus: http.StatusOK, wantRemaining: 2, wantReset: time.Minute},
+				{advance: 45 * time.Second, userID: 1, wantStatus: http.StatusTooManyRequests, wantReset: time.Minute, wantRetry: 15},
+				// The three earlier requests leave the window together, so
+				// the one made now is the oldest in it.
+				{advance: 16 * time.Second, userID: 1, wantStatus: http.StatusOK, wantRemaining: 2, wantReset: 2*time.Minute + time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.TriggerRateLimit = 3
+			cfg.TriggerRateWindow = time.Minute
+			cfg.RateLimitAlgorithm = tt.algorithm
+			s, _, clock := newTestServer(t, cfg)
+			start := clock.Now()
+
+			for i, st := range tt.steps {
+				clock.Advance(st.advance)
+				w := serve(s, authRequest(t, http.MethodPost, "/trigger", st.userID,
+					strings.NewReader(`{"event_type":"notification","payload":{}}`)))
+
+				h := w.Header()
+				if w.Code != st.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d; body %s", i, w.Code, st.wantStatus, w.Body)
+				}
+				if got := h.Get("X-RateLimit-Limit"); got != "3" {
+					t.Errorf("request %d: X-RateLimit-Limit = %q, want 3", i, got)
+				}
+				if got := h.Get("X-RateLimit-Remaining"); got != strconv.Itoa(st.wantRemaining) {
+					t.Errorf("request %d: X-RateLimit-Remaining = %q, want %d", i, got, st.wantRemaining)
+				}
+				reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+				if err != nil {
+					t.Errorf("request %d: X-RateLimit-Reset = %q: %v", i, h.Get("X-RateLimit-Reset"), err)
+				} else if want := start.Add(st.wantReset).Unix(); reset != want {
+					t.Errorf("request %d: X-RateLimit-Reset = %d, want %d", i, reset, want)
+				}
+				wantRetry := ""
+				if st.wantRetry > 0 {
+					wantRetry = strconv.Itoa(st.wantRetry)
+				}
+				if got := h.Get("Retry-After"); got != wantRetry {
+					t.Errorf("request %d: Retry-After = %q, want %q", i, got, wantRetry)
+				}
+			}
+		})
+	}
+}
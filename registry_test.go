@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkRegistryChurn connects and disconnects clients from parallel
+// goroutines while n clients are already registered and every tenth
+// operation is a broadcast, the mix the registry sees in production.
+func BenchmarkRegistryChurn(b *testing.B) {
+	for _, kind := range registryKinds {
+		for _, n := range []int{1_000, 10_000, 100_000} {
+			b.Run(fmt.Sprintf("%s/%d", kind.name, n), func(b *testing.B) {
+				cfg := testConfig()
+				cfg.UseSyncMap = kind.useSyncMap
+				s, _, _ := newTestServer(b, cfg)
+				addClients(s, n, "idle", 0)
+				frame := eventFrame("id", legacyEventType, []byte(`{"number":1}`))
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						if i++; i%10 == 0 {
+							s.broadcastToChannel(context.Background(), defaultChannel, frame)
+							continue
+						}
+						ch := make(chan *Frame, 1)
+						s.clients.Add(ch, &ClientMeta{Channel: defaultChannel})
+						s.clients.Remove(ch)
+					}
+				})
+			})
+		}
+	}
+}
+
+// TestConcurrentRemove removes every client twice, from two goroutines,
+// while broadcasts run, as a logout and the client's own handler may. It
+// fails by panicking on a double close or a send on a closed channel;
+// run it with -race to also check the registry's locking.
+func TestConcurrentRemove(t *testing.T) {
+	const clients = 200
+	for _, kind := range registryKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.UseSyncMap = kind.useSyncMap
+			s, _, _ := newTestServer(t, cfg)
+			chans := addClients(s, clients, defaultChannel, 1)
+			frame := eventFrame("id", legacyEventType, []byte(`{"number":1}`))
+
+			stop := make(chan struct{})
+			var broadcasts sync.WaitGroup
+			for range 4 {
+				broadcasts.Go(func() {
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							s.broadcastToChannel(context.Background(), defaultChannel, frame)
+						}
+					}
+				})
+			}
+
+			var removes sync.WaitGroup
+			for range 2 {
+				removes.Go(func() {
+					for _, ch := range chans {
+						s.clients.Remove(ch)
+					}
+				})
+			}
+			removes.Wait()
+			close(stop)
+			broadcasts.Wait()
+
+			if n := s.TotalClients(); n != 0 {
+				t.Errorf("%d clients left registered", n)
+			}
+		})
+	}
+}
+
+func TestSafeCloseConcurrent(t *testing.T) {
+	meta := &ClientMeta{}
+	ch := make(chan *Frame)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() { meta.safeClose(ch) })
+	}
+	wg.Wait()
+	if _, ok := <-ch; ok {
+		t.Error("channel not closed")
+	}
+}
+
+func TestSubscriberCount(t *testing.T) {
+	for _, kind := range registryKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.UseSyncMap = kind.useSyncMap
+			s, _, _ := newTestServer(t, cfg)
+			ts := startServer(t, s)
+
+			type counts struct{ room, other, total int }
+			check := func(want counts) {
+				t.Helper()
+				waitFor(t, time.Second, func() bool {
+					return s.SubscriberCount("room") == want.room &&
+						s.SubscriberCount("other") == want.other &&
+						s.TotalClients() == want.total
+				})
+			}
+
+			check(counts{})
+			room1 := openStream(t, ts, "/events?channel=room", "")
+			room2 := openStream(t, ts, "/events?channel=room", "")
+			other := openStream(t, ts, "/events?channel=other", "")
+			check(counts{room: 2, other: 1, total: 3})
+
+			room1.Body.Close()
+			check(counts{room: 1, other: 1, total: 2})
+			other.Body.Close()
+			check(counts{room: 1, total: 1})
+			room2.Body.Close()
+			check(counts{})
+		})
+	}
+}
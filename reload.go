@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// reloadEvery calls load every interval until the server shuts down. A
+// failed load is logged and the previously loaded state is kept.
+func (s *Server) reloadEvery(interval time.Duration, what string, load func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := load(s.ctx); err != nil {
+				s.logger.Error("Failed to reload "+what, "error", err)
+			}
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// reloadOnSignal calls load each time the process receives sig, until the
+// server shuts down.
+func (s *Server) reloadOnSignal(sig os.Signal, what string, load func(context.Context) error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, sig)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-sigs:
+			if err := load(s.ctx); err != nil {
+				s.logger.Error("Failed to reload "+what, "error", err)
+				continue
+			}
+			s.logger.Info("Reloaded "+what, "signal", sig.String())
+		case <-s.Done():
+			return
+		}
+	}
+}
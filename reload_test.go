@@ -0,0 +1,75 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger func(t *testing.T)
+		start   func(s *Server, load func(context.Context) error)
+	}{
+		{
+			name:  "interval",
+			start: func(s *Server, load func(context.Context) error) { s.reloadEvery(10*time.Millisecond, "test", load) },
+		},
+		{
+			name: "SIGHUP",
+			// The reloader may register after the first signal, so it is
+			// sent until a load happens.
+			trigger: func(t *testing.T) {
+				if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+					t.Fatal(err)
+				}
+			},
+			start: func(s *Server, load func(context.Context) error) { s.reloadOnSignal(syscall.SIGHUP, "test", load) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// SIGHUP ends a process that does not handle it.
+			caught := make(chan os.Signal, 1)
+			signal.Notify(caught, syscall.SIGHUP)
+			defer signal.Stop(caught)
+
+			s, _, _ := newTestServer(t, testConfig())
+			var loads atomic.Int64
+			load := func(context.Context) error {
+				// A failed load must not stop later ones.
+				if loads.Add(1) == 1 {
+					return errors.New("database unavailable")
+				}
+				return nil
+			}
+			done := make(chan struct{})
+			go func() {
+				tt.start(s, load)
+				close(done)
+			}()
+
+			waitFor(t, 5*time.Second, func() bool {
+				if tt.trigger != nil {
+					tt.trigger(t)
+				}
+				return loads.Load() >= 2
+			})
+
+			s.cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Error("reloader still running after the server stopped")
+			}
+		})
+	}
+}
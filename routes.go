@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// routes builds the handler NewServer stores for ServeHTTP.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", s.metricsHandler())
+	mux.HandleFunc("/events", s.withConnKind(connSSE, s.debugUIMiddleware(s.originMiddleware(s.queryTokenMiddleware(s.optionalAuthMiddleware(s.sseHandler))))))
+	mux.HandleFunc("/trigger", allowMethods("trigger", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.authMiddleware(s.triggerRateLimit(s.triggerHandler)))))
+	mux.HandleFunc("/v1/events/history/{id}", allowMethods("trigger", []string{http.MethodGet},
+		s.withConnKind(connAPI, s.authMiddleware(s.eventHistoryHandler))))
+	mux.HandleFunc("/channels", allowMethods("channels", []string{http.MethodGet, http.MethodPost},
+		s.withConnKind(connAPI, s.authMiddleware(s.channelsHandler))))
+	mux.HandleFunc("/channels/{name}", allowMethods("channels", []string{http.MethodPatch},
+		s.withConnKind(connAPI, s.authMiddleware(s.requireOwner(s.updateChannelHandler)))))
+	mux.HandleFunc("/channels/{name}/publish", allowMethods("channels", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.authMiddleware(s.requirePublisher(s.triggerRateLimit(s.triggerHandler))))))
+	mux.HandleFunc("/channels/{name}/subscribers", allowMethods("channels", []string{http.MethodGet},
+		s.withConnKind(connAPI, s.authMiddleware(s.requireOwner(s.channelSubscribersHandler)))))
+	mux.HandleFunc("/admin/stats", allowMethods("admin", []string{http.MethodGet},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminStatsHandler))))
+	mux.HandleFunc("/admin/purge-events", allowMethods("admin", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminPurgeEventsHandler))))
+	mux.HandleFunc("/admin/invalidate-cache", allowMethods("admin", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminInvalidateCacheHandler))))
+	mux.HandleFunc("/admin/users/{id}/verify", allowMethods("admin", []string{http.MethodPost, http.MethodDelete},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminSetVerificationHandler))))
+	mux.HandleFunc("/admin/disconnect/{user_id}", allowMethods("admin", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminDisconnectHandler))))
+	if s.config.EnableSimulate {
+		mux.HandleFunc("/admin/simulate-slow-client", allowMethods("simulated-slow-clients", []string{http.MethodPost},
+			s.withConnKind(connAPI, s.adminMiddleware(s.adminSimulateSlowClientHandler))))
+	}
+	mux.HandleFunc("/admin/test-broadcast", allowMethods("synthetic-monitoring", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminTestBroadcastHandler))))
+	mux.HandleFunc("/admin/test-ack", allowMethods("synthetic-monitoring", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminTestAckHandler))))
+	mux.HandleFunc("/admin/channels/{name}/replay", allowMethods("replay", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminReplayHandler))))
+	mux.HandleFunc("/admin/channels/{name}/metrics", allowMethods("admin", []string{http.MethodGet},
+		s.withConnKind(connAPI, s.adminMiddleware(s.adminChannelMetricsHandler))))
+	mux.HandleFunc("/admin/channels/{name}/schema", allowMethods("channel-schemas", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.adminMiddleware(s.setChannelSchemaHandler))))
+	mux.HandleFunc("/pong", allowMethods("latency", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.pongHandler)))
+	mux.HandleFunc("/auth/refresh", allowMethods("token-refresh", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.authMiddleware(s.refreshHandler))))
+	mux.HandleFunc("/auth/logout", allowMethods("sessions", []string{http.MethodPost},
+		s.withConnKind(connAPI, s.authMiddleware(s.logoutHandler))))
+
+	return requestIDMiddleware(securityHeadersMiddleware(s.config.ContentSecurityPolicy, s.corsMiddleware(mux)))
+}
+
+// ServeHTTP dispatches to the service's endpoints, so a Server can be
+// used directly as an http.Server's Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Routes returns the server's endpoints mounted under prefix, for
+// applications that embed the service in their own mux:
+//
+//	mux.Handle("/notifications/", srv.Routes("/notifications/"))
+func (s *Server) Routes(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	stripped := http.StripPrefix(prefix, s)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stripped.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routePrefixKey, prefix)))
+	})
+}
+
+// routePrefix returns the prefix the request came in under, without the
+// trailing slash, or "" when s is not mounted through Routes. Links to
+// the server's own endpoints start with it.
+func routePrefix(ctx context.Context) string {
+	prefix, _ := ctx.Value(routePrefixKey).(string)
+	return prefix
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutesUnderPrefix(t *testing.T) {
+	s, _, _ := newTestServer(t, testConfig())
+	ts := httptest.NewServer(s.Routes("/n/"))
+	t.Cleanup(ts.Close)
+
+	userToken := signToken(t, &Claims{UserID: 1})
+	adminToken := signToken(t, &Claims{UserID: 1, Role: roleAdmin})
+	do := func(t *testing.T, method, path, token, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	trigger := do(t, http.MethodPost, "/n/trigger", userToken, `{"event_type":"notification","payload":{}}`)
+	if trigger.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(trigger.Body)
+		t.Fatalf("trigger: status %d, body %s", trigger.StatusCode, body)
+	}
+	location := trigger.Header.Get("Location")
+	if !strings.HasPrefix(location, "/n/v1/events/history/") {
+		t.Fatalf("Location = %q, want it under /n/", location)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"metrics", http.MethodGet, "/n/metrics", "", "", http.StatusOK},
+		{"trigger", http.MethodPost, "/n/trigger", userToken, `{"event_type":"notification","payload":{}}`, http.StatusOK},
+		{"trigger without token", http.MethodPost, "/n/trigger", "", `{}`, http.StatusUnauthorized},
+		{"trigger with wrong method", http.MethodGet, "/n/trigger", userToken, "", http.StatusMethodNotAllowed},
+		{"event history", http.MethodGet, location, userToken, "", http.StatusOK},
+		{"channels", http.MethodGet, "/n/channels", userToken, "", http.StatusOK},
+		{"admin as user", http.MethodGet, "/n/admin/stats", userToken, "", http.StatusForbidden},
+		{"admin", http.MethodGet, "/n/admin/stats", adminToken, "", http.StatusOK},
+		{"path outside the prefix", http.MethodPost, "/trigger", userToken, `{}`, http.StatusNotFound},
+		{"unknown path", http.MethodGet, "/n/nope", "", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(t, tt.method, tt.path, tt.token, tt.body)
+			if resp.StatusCode != tt.wantStatus {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("status = %d, want %d; body %s", resp.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+
+	t.Run("events", func(t *testing.T) {
+		resp := do(t, http.MethodGet, "/n/events", "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want 200", resp.StatusCode)
+		}
+		if ev := readEvent(t, bufio.NewReader(resp.Body)); !strings.Contains(ev.Data, `"status":"connected"`) {
+			t.Errorf("first event = %+v", ev)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"path"
+	"sync"
+)
+
+type routingRule struct {
+	EventTypePattern string
+	TargetChannel    string
+	Priority         int
+}
+
+// routingTable holds the routing_rules table. Rules copy events whose type
+// matches a glob pattern into an additional channel.
+type routingTable struct {
+	mu    sync.RWMutex
+	rules []routingRule
+}
+
+// Targets returns channel plus the target of every rule matching
+// eventType.
+func (t *routingTable) Targets(channel, eventType string) map[string]bool {
+	targets := map[string]bool{channel: true}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, rule := range t.rules {
+		if ok, _ := path.Match(rule.EventTypePattern, eventType); ok {
+			targets[rule.TargetChannel] = true
+		}
+	}
+	return targets
+}
+
+func (t *routingTable) set(rules []routingRule) {
+	t.mu.Lock()
+	t.rules = rules
+	t.mu.Unlock()
+}
+
+func (s *Server) loadRoutingRules(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT event_type_pattern, target_channel, priority FROM routing_rules ORDER BY priority DESC")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rules []routingRule
+	for rows.Next() {
+		var rule routingRule
+		if err := rows.Scan(&rule.EventTypePattern, &rule.TargetChannel, &rule.Priority); err != nil {
+			return err
+		}
+		if _, err := path.Match(rule.EventTypePattern, ""); err != nil {
+			s.logger.Warn("Skipping routing rule with invalid pattern", "pattern", rule.EventTypePattern, "error", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.routing.set(rules)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+const schemaCacheTTL = 5 * time.Minute
+
+// schemaCache holds the compiled schema of each channel looked up in the
+// last schemaCacheTTL. A nil schema records that the channel has none.
+type schemaCache struct {
+	mu      sync.Mutex
+	entries map[string]schemaCacheEntry
+}
+
+type schemaCacheEntry struct {
+	schema  *jsonschema.Schema
+	expires time.Time
+}
+
+func (c *schemaCache) get(channel string, now time.Time) (*jsonschema.Schema, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[channel]
+	if !ok || now.After(e.expires) {
+		return nil, false
+	}
+	return e.schema, true
+}
+
+func (c *schemaCache) put(channel string, schema *jsonschema.Schema, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]schemaCacheEntry)
+	}
+	c.entries[channel] = schemaCacheEntry{schema: schema, expires: now.Add(schemaCacheTTL)}
+}
+
+// compileSchema compiles a draft 2020-12 JSON Schema document. Documents
+// that declare a different $schema are compiled under that draft.
+func compileSchema(raw []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	if err := c.AddResource("schema.json", doc); err != nil {
+		return nil, err
+	}
+	return c.Compile("schema.json")
+}
+
+// channelSchema returns the schema events on channel must match, or nil
+// when it has none.
+func (s *Server) channelSchema(ctx context.Context, channel string) (*jsonschema.Schema, error) {
+	now := s.clock.Now()
+	if schema, ok := s.schemas.get(channel, now); ok {
+		return schema, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, "SELECT schema FROM event_schemas WHERE channel = $1", channel).Scan(&raw)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var schema *jsonschema.Schema
+	if raw != nil {
+		if schema, err = compileSchema(raw); err != nil {
+			return nil, err
+		}
+	}
+	s.schemas.put(channel, schema, now)
+	return schema, nil
+}
+
+// validatePayload checks payload against schema and returns the
+// individual failures, or nil when it is valid. A missing payload is
+// validated as null.
+func validatePayload(schema *jsonschema.Schema, payload json.RawMessage) ([]map[string]string, error) {
+	if payload == nil {
+		payload = json.RawMessage("null")
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	err = schema.Validate(inst)
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return nil, err
+	}
+
+	var failures []map[string]string
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		failures = append(failures, map[string]string{
+			"instance_location": unit.InstanceLocation,
+			"keyword_location":  unit.KeywordLocation,
+			"message":           unit.Error.String(),
+		})
+	}
+	if failures == nil {
+		failures = []map[string]string{{"instance_location": "", "message": verr.Error()}}
+	}
+	return failures, nil
+}
+
+// setChannelSchemaHandler stores the request body as the JSON Schema of
+// the channel in the path, replacing any previous one.
+func (s *Server) setChannelSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	channel := r.PathValue("name")
+
+	var raw json.RawMessage
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, s.config.MaxPayloadBytes), &raw); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	schema, err := compileSchema(raw)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "invalid_schema",
+			"detail": err.Error(),
+		})
+		return
+	}
+
+	_, err = s.db.ExecContext(r.Context(), `
+		INSERT INTO event_schemas (channel, schema) VALUES ($1, $2)
+		ON CONFLICT (channel) DO UPDATE SET schema = EXCLUDED.schema, updated_at = NOW()`,
+		channel, []byte(raw))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		}
+		s.logger.Error("Failed to save channel schema", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.schemas.put(channel, schema, s.clock.Now())
+	s.logger.Info("Channel schema updated", "channel", channel)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	secretScheme = "secret://"
+
+	// secretResolveTimeout bounds how long loadConfig waits for all
+	// secret:// references together.
+	secretResolveTimeout = 10 * time.Second
+)
+
+// SecretResolver fetches secrets for one provider. ref is what follows the
+// provider in a secret:// value, e.g. "my-secret" in secret://aws/my-secret.
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// secretResolvers maps the provider part of a secret:// value to the
+// resolver that handles it.
+var secretResolvers = map[string]SecretResolver{
+	"env":   EnvSecretResolver{},
+	"aws":   &AWSSecretManagerResolver{},
+	"vault": &VaultResolver{},
+}
+
+// RegisterSecretResolver makes secret://<provider>/... values resolve
+// through r, replacing any built-in resolver for provider. It must be
+// called before loadConfig.
+func RegisterSecretResolver(provider string, r SecretResolver) {
+	secretResolvers[provider] = r
+}
+
+// EnvSecretResolver reads secret://env/NAME from the variable NAME, which
+// lets a deployment point several settings at one variable. Values that
+// are not secret:// references never reach a resolver and are used as
+// they are.
+type EnvSecretResolver struct{}
+
+func (EnvSecretResolver) Resolve(_ context.Context, ref string) (string, error) {
+	v, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return v, nil
+}
+
+// resolvedEnv holds the resolved values of variables that were secret://
+// references. They are kept here rather than written back with os.Setenv
+// so the secrets never enter the process environment, where child
+// processes and /proc/<pid>/environ would see them.
+var resolvedEnv map[string]string
+
+// getenv is os.Getenv with secret:// references replaced by the values
+// resolveEnvSecrets fetched for them.
+func getenv(key string) string {
+	if v, ok := resolvedEnv[key]; ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+// resolveEnvSecrets resolves every secret:// variable in the environment.
+// Variables that fail are reported together by name; their references
+// are left out of the error since they may reveal more than a name.
+func resolveEnvSecrets(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, secretResolveTimeout)
+	defer cancel()
+
+	resolved := make(map[string]string)
+	var errs []error
+	for _, kv := range os.Environ() {
+		key, v, _ := strings.Cut(kv, "=")
+		rest, ok := strings.CutPrefix(v, secretScheme)
+		if !ok {
+			continue
+		}
+
+		provider, ref, _ := strings.Cut(rest, "/")
+		r, ok := secretResolvers[provider]
+		if !ok || ref == "" {
+			errs = append(errs, fmt.Errorf("%s: unsupported secret provider %q", key, provider))
+			continue
+		}
+		secret, err := r.Resolve(ctx, ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		resolved[key] = secret
+	}
+
+	resolvedEnv = resolved
+	return errors.Join(errs...)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// AWSSecretManagerResolver reads secret://aws/<id> from AWS Secrets
+// Manager, where id is a secret name or ARN. Credentials and region come
+// from the SDK's default chain: AWS_REGION, the shared config files or
+// the instance role. The value must be a string secret; it is used as is.
+type AWSSecretManagerResolver struct {
+	once   sync.Once
+	client *secretsmanager.Client
+	err    error
+}
+
+func (r *AWSSecretManagerResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	r.once.Do(func() {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			r.err = fmt.Errorf("load AWS config: %w", err)
+			return
+		}
+		r.client = secretsmanager.NewFromConfig(cfg)
+	})
+	if r.err != nil {
+		return "", r.err
+	}
+
+	out, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(ref),
+	})
+	if err != nil {
+		return "", fmt.Errorf("get AWS secret %s: %w", ref, err)
+	}
+	if out.SecretString == nil {
+		return "", errors.New("AWS secret " + ref + " has no string value")
+	}
+	return *out.SecretString, nil
+}
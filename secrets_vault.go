@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// VaultResolver reads secret://vault/<path>#<field> from HashiCorp Vault
+// over its HTTP API, using VAULT_ADDR and VAULT_TOKEN. The field defaults
+// to "value". Both KV v1 and v2 responses are understood; for v2 the path
+// includes the data/ segment, e.g. secret/data/peeple#jwt_secret.
+type VaultResolver struct {
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (r *VaultResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set to read Vault secrets")
+	}
+
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok {
+		field = "value"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("read Vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("read Vault secret %s: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := decodeJSON(resp.Body, &body); err != nil {
+		return "", fmt.Errorf("decode Vault secret %s: %w", path, err)
+	}
+
+	data := body.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		data = inner
+	}
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no string field %q", path, field)
+	}
+	return v, nil
+}
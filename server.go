@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Server struct {
+	db               *sql.DB
+	readDB           *sql.DB
+	config           Config
+	clients          clientRegistry
+	logger           *slog.Logger
+	clock            Clock
+	registry         *prometheus.Registry
+	metrics          *serverMetrics
+	blobs            BlobStore
+	store            MessageStore
+	triggers         *TriggerService
+	streams          *streamPool
+	broadcastWorkers int
+	eventTypes       eventTypeRegistry
+	channels         *ChannelRegistry
+	routing          routingTable
+	jtis             jtiCache
+	schemas          schemaCache
+	pongs            ipLimiter
+	channelBuckets   sync.Map // channel name -> *channelLimit
+	testAcks         sync.Map // nonce -> chan time.Time
+	triggerLimiter   rateLimiter
+	authCache        *authCache
+	channelStats     sync.Map // channel name -> *channelStats
+	dedup            dedupRing
+	startedAt        time.Time
+
+	totalBroadcasts atomic.Int64
+	totalDropped    atomic.Int64
+
+	activeSSE    atomic.Int64
+	activeAPI    atomic.Int64
+	rejectAPI    atomic.Bool
+	sseClosed    chan struct{}
+	closeSSEOnce sync.Once
+
+	handler    http.Handler
+	httpServer *http.Server
+
+	// ctx lives as long as the server; cancel is called by Shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Option configures optional Server dependencies in NewServer.
+type Option func(*Server)
+
+// Clock abstracts time so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// BlobStore holds payloads that are too large to keep inline.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+func NewServer(db *sql.DB, cfg Config, opts ...Option) *Server {
+	s := &Server{
+		db:               db,
+		readDB:           db,
+		config:           cfg,
+		clients:          newClientRegistry(cfg.UseSyncMap),
+		logger:           slog.New(newTracelogHandler(slog.NewJSONHandler(os.Stdout, nil))),
+		clock:            systemClock{},
+		store:            &pgMessageStore{db: db},
+		registry:         prometheus.NewRegistry(),
+		broadcastWorkers: 1,
+		sseClosed:        make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.pongs.interval = pongInterval
+	s.authCache = newAuthCache(cfg.AuthCacheTTL)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.channels = &ChannelRegistry{db: s.db}
+	s.triggers = &TriggerService{srv: s}
+	if cfg.EnableTCPTuning && cfg.SSEClientsPerWorker > 0 {
+		s.streams = newStreamPool(s, cfg.SSEClientsPerWorker)
+	}
+	s.metrics = newServerMetrics(s.registry)
+	s.startedAt = s.clock.Now()
+	s.triggerLimiter = newTriggerLimiter(cfg, s.logger)
+	s.handler = s.routes()
+	s.httpServer = &http.Server{
+		Handler:  s,
+		ErrorLog: log.New(slogAdapter{logger: s.logger}, "", 0),
+	}
+
+	return s
+}
+
+// ServeListener serves HTTP on l until Shutdown is called, after which it
+// returns http.ErrServerClosed. Passing the listener in lets tests bind an
+// ephemeral port and lets main hand over a socket-activated one.
+func (s *Server) ServeListener(l net.Listener) error {
+	return s.httpServer.Serve(l)
+}
+
+// Done is closed when Shutdown starts. Background goroutines stop on it,
+// and work they start uses a context cancelled at the same moment.
+func (s *Server) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Context returns the server's lifecycle context. Work started by a
+// handler that should outlive the request, such as an audit insert, can
+// use it and still stop when Shutdown begins.
+func (s *Server) Context() context.Context {
+	return s.ctx
+}
+
+// SubscriberCount returns how many clients on this instance are
+// subscribed to channel. It is safe to call from any goroutine.
+func (s *Server) SubscriberCount(channel string) int {
+	n := 0
+	s.clients.View(func(clients []clientEntry) {
+		for _, c := range clients {
+			if c.meta.Channel == channel {
+				n++
+			}
+		}
+	})
+	return n
+}
+
+// Triggers returns the service behind POST /trigger, for code that wants
+// to trigger events without going through HTTP.
+func (s *Server) Triggers() *TriggerService {
+	return s.triggers
+}
+
+// Channels returns the registry of channels in the channels table.
+func (s *Server) Channels() *ChannelRegistry {
+	return s.channels
+}
+
+// TotalClients returns how many clients are connected to this instance
+// across all channels. It is safe to call from any goroutine.
+func (s *Server) TotalClients() int {
+	return s.clients.Len()
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+func WithMetricsRegistry(reg *prometheus.Registry) Option {
+	return func(s *Server) {
+		s.registry = reg
+	}
+}
+
+func WithBlobStore(store BlobStore) Option {
+	return func(s *Server) {
+		s.blobs = store
+	}
+}
+
+// WithReadReplica routes read-only queries to db instead of the primary.
+func WithReadReplica(db *sql.DB) Option {
+	return func(s *Server) {
+		s.readDB = db
+	}
+}
+
+// WithBroadcastWorkers sets how many goroutines share the fan-out of a
+// single broadcast. Values below 1 are ignored.
+func WithBroadcastWorkers(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.broadcastWorkers = n
+		}
+	}
+}
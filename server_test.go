@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testJWTSecret = "test-secret-that-is-long-enough-for-validation"
+
+// testConfig returns the defaults loadConfig would produce, with
+// background behaviour such as heartbeats and rate limits turned off so
+// tests only see what they enable.
+func testConfig() Config {
+	return Config{
+		AppEnv:                appEnvDevelopment,
+		Port:                  "0",
+		JwtSecret:             []byte(testJWTSecret),
+		SSERetry:              3 * time.Second,
+		SSEOverloadRetry:      30 * time.Second,
+		TokenTTL:              time.Hour,
+		ShutdownSSETimeout:    time.Second,
+		ShutdownAPITimeout:    time.Second,
+		BackpressureStrategy:  BackpressureDrop,
+		BackpressureTimeout:   100 * time.Millisecond,
+		MaxPayloadBytes:       64 << 10,
+		TriggerRateWindow:     time.Minute,
+		RateLimitAlgorithm:    rateLimitFixedWindow,
+		ChannelBurst:          200,
+		ScheduleMaxAdvance:    30 * 24 * time.Hour,
+		AsyncMaxRetries:       3,
+		RetentionDays:         30,
+		ChannelIdleTTL:        24 * time.Hour,
+		ChannelNamePattern:    defaultChannelNamePattern,
+		ReservedChannelNames:  defaultReservedChannelNames,
+		ContentSecurityPolicy: "default-src 'none'",
+	}
+}
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestServer returns a Server on a fakeDB with a MemoryMessageStore, a
+// silent logger and a fakeClock. Unless the test replaces the handler,
+// every user exists and has not submitted verification.
+func newTestServer(t testing.TB, cfg Config, opts ...Option) (*Server, *fakeDB, *fakeClock) {
+	t.Helper()
+
+	db, fake := newFakeDB(t, unverifiedUsers)
+	clock := newFakeClock()
+	opts = append([]Option{
+		WithLogger(slog.New(slog.DiscardHandler)),
+		WithClock(clock),
+		WithMessageStore(NewMemoryMessageStore()),
+	}, opts...)
+
+	s := NewServer(db, cfg, opts...)
+	t.Cleanup(s.cancel)
+	return s, fake, clock
+}
+
+// unverifiedUsers answers the authMiddleware lookup with a user that has
+// not submitted verification and everything else with no rows.
+func unverifiedUsers(q fakeQuery) fakeResult {
+	if strings.Contains(q.SQL, "verification_status FROM users") {
+		return fakeRow(false)
+	}
+	return fakeResult{}
+}
+
+// signToken returns a token for claims signed with testJWTSecret. A
+// claims without an expiry gets one an hour from now.
+func signToken(t testing.TB, claims *Claims) string {
+	t.Helper()
+
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return token
+}
+
+// authRequest builds a request carrying a token for userID.
+func authRequest(t testing.TB, method, target string, userID uint64, body io.Reader) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, &Claims{UserID: userID}))
+	return r
+}
+
+// serve runs r through s and returns the recorded response.
+func serve(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+const (
+	jtiCacheTTL     = 60 * time.Second
+	jtiCacheMaxSize = 10000
+	dbQueryTimeout  = 3 * time.Second
+)
+
+// jtiCache remembers whether a token ID passed the session and
+// revocation checks, so hot tokens do not hit the database on every
+// request. A revocation can take up to jtiCacheTTL to be noticed on
+// instances other than the one that performed it.
+type jtiCache struct {
+	mu      sync.Mutex
+	entries map[string]jtiCacheEntry
+}
+
+type jtiCacheEntry struct {
+	valid   bool
+	expires time.Time
+}
+
+func (c *jtiCache) get(jti string, now time.Time) (valid, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[jti]
+	if !ok || now.After(e.expires) {
+		return false, false
+	}
+	return e.valid, true
+}
+
+func (c *jtiCache) put(jti string, valid bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]jtiCacheEntry)
+	}
+	if len(c.entries) >= jtiCacheMaxSize {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) < jtiCacheMaxSize {
+		c.entries[jti] = jtiCacheEntry{valid: valid, expires: now.Add(jtiCacheTTL)}
+	}
+}
+
+func (c *jtiCache) delete(jti string) {
+	c.mu.Lock()
+	delete(c.entries, jti)
+	c.mu.Unlock()
+}
+
+// sessionValid reports whether jti may be used: its session, if it has a
+// row in sessions, is neither expired nor revoked, and it is not on the
+// revocation list. Tokens issued before sessions were recorded have no
+// row and stay valid until their exp.
+func (s *Server) sessionValid(ctx context.Context, jti string) (bool, error) {
+	now := s.clock.Now()
+	if valid, ok := s.jtis.get(jti, now); ok {
+		return valid, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var valid bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT NOT EXISTS(
+			SELECT 1 FROM sessions
+			WHERE jti = $1 AND (expires_at <= NOW() OR revoked_at IS NOT NULL)
+		) AND NOT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)`, jti).Scan(&valid)
+	if err != nil {
+		return false, err
+	}
+
+	s.jtis.put(jti, valid, now)
+	return valid, nil
+}
+
+// rotateSession records the session for newJTI and revokes oldJTI in one
+// transaction, so a refresh never leaves both tokens usable.
+func (s *Server) rotateSession(ctx context.Context, userID uint64, oldJTI string, oldExpiry time.Time, newJTI string, newExpiry time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO sessions (jti, user_id, expires_at) VALUES ($1, $2, $3)",
+		newJTI, userID, newExpiry); err != nil {
+		return err
+	}
+
+	if oldJTI != "" {
+		if err := revokeJTI(ctx, tx, oldJTI, oldExpiry); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.jtis.delete(oldJTI)
+	return nil
+}
+
+// revokeSession revokes the session for jti and adds it to
+// revoked_tokens in one transaction.
+func (s *Server) revokeSession(ctx context.Context, jti string, expiresAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeJTI(ctx, tx, jti, expiresAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.jtis.delete(jti)
+	return nil
+}
+
+func revokeJTI(ctx context.Context, tx *sql.Tx, jti string, expiresAt time.Time) error {
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = NOW() WHERE jti = $1 AND revoked_at IS NULL", jti); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING",
+		jti, expiresAt)
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSessionCheck(t *testing.T) {
+	// session is a row of sessions; nil means the jti has none.
+	type session struct{ expired, revoked bool }
+	tests := []struct {
+		name         string
+		session      *session
+		revokedToken bool
+		wantStatus   int
+	}{
+		{name: "no session row", wantStatus: http.StatusNotFound},
+		{name: "live session", session: &session{}, wantStatus: http.StatusNotFound},
+		{name: "expired session", session: &session{expired: true}, wantStatus: http.StatusUnauthorized},
+		{name: "revoked session", session: &session{revoked: true}, wantStatus: http.StatusUnauthorized},
+		{name: "revoked token", session: &session{}, revokedToken: true, wantStatus: http.StatusUnauthorized},
+		{name: "revoked token without session row", revokedToken: true, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, clock := newTestServer(t, testConfig())
+			fake.setHandler(func(q fakeQuery) fakeResult {
+				if strings.Contains(q.SQL, "FROM sessions") {
+					// The query must reject on a bad row rather than
+					// require a good one.
+					if !strings.Contains(q.SQL, "SELECT NOT EXISTS(") {
+						t.Errorf("session query requires a row:%s", q.SQL)
+					}
+					bad := tt.session != nil && (tt.session.expired || tt.session.revoked)
+					return fakeRow(!bad && !tt.revokedToken)
+				}
+				return unverifiedUsers(q)
+			})
+
+			token := signToken(t, &Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{ID: "jti-1"}})
+			request := func() int {
+				r := httptest.NewRequest(http.MethodGet, "/v1/events/history/00000000-0000-0000-0000-000000000000", nil)
+				r.Header.Set("Authorization", "Bearer "+token)
+				return serve(s, r).Code
+			}
+
+			if got := request(); got != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", got, tt.wantStatus)
+			}
+			if got := request(); got != tt.wantStatus {
+				t.Fatalf("cached status = %d, want %d", got, tt.wantStatus)
+			}
+			if n := fake.countQueries("FROM sessions"); n != 1 {
+				t.Errorf("looked up the session %d times within the cache TTL, want 1", n)
+			}
+
+			clock.Advance(jtiCacheTTL + time.Second)
+			request()
+			if n := fake.countQueries("FROM sessions"); n != 2 {
+				t.Errorf("looked up the session %d times after the cache TTL, want 2", n)
+			}
+		})
+	}
+}
+
+func TestTokensWithoutJTISkipSessionCheck(t *testing.T) {
+	s, fake, _ := newTestServer(t, testConfig())
+
+	w := serve(s, authRequest(t, http.MethodGet, "/v1/events/history/00000000-0000-0000-0000-000000000000", 1, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404; body %s", w.Code, w.Body)
+	}
+	if n := fake.countQueries("FROM sessions"); n != 0 {
+		t.Errorf("ran %d session queries for a token without jti", n)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// connKind tells the shutdown logic how long a request may take to drain.
+type connKind int
+
+const (
+	connAPI connKind = iota
+	connSSE
+)
+
+const sseDrainPollInterval = 50 * time.Millisecond
+
+// shutdownCloseMargin is how long past the SSE grace period Shutdown
+// waits, so streams closed when it ends can send their close event and
+// return before the deadline.
+const shutdownCloseMargin = time.Second
+
+// withConnKind tags the request context with its connection kind and
+// rejects API requests once the API grace period has passed.
+func (s *Server) withConnKind(kind connKind, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if kind == connAPI && s.rejectAPI.Load() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		if kind == connSSE {
+			w.Header().Del("Content-Security-Policy")
+			s.activeSSE.Add(1)
+			defer s.activeSSE.Add(-1)
+		} else {
+			s.activeAPI.Add(1)
+			defer s.activeAPI.Add(-1)
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), connKindKey, kind)))
+	}
+}
+
+// Shutdown drains the HTTP server started by ServeListener. API requests get ShutdownAPITimeout before
+// new ones are refused; SSE streams get ShutdownSSETimeout before they are
+// closed. The server-wide deadline is the longer of the two, plus
+// shutdownCloseMargin.
+func (s *Server) Shutdown() error {
+	grace := max(s.config.ShutdownSSETimeout, s.config.ShutdownAPITimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), grace+shutdownCloseMargin)
+	defer cancel()
+
+	s.logger.Info("Shutting down",
+		"active_sse", s.activeSSE.Load(),
+		"active_api", s.activeAPI.Load(),
+		"grace", grace.String())
+
+	apiTimer := time.AfterFunc(s.config.ShutdownAPITimeout, func() {
+		s.rejectAPI.Store(true)
+	})
+	defer apiTimer.Stop()
+
+	sseTimer := time.AfterFunc(s.config.ShutdownSSETimeout, func() {
+		s.logger.Info("Closing remaining SSE connections", "active_sse", s.activeSSE.Load())
+		s.closeSSEOnce.Do(func() { close(s.sseClosed) })
+	})
+	defer sseTimer.Stop()
+
+	s.cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	// net/http does not wait for hijacked connections, so streams written
+	// with ENABLE_TCP_TUNING are waited for here.
+	ticker := time.NewTicker(sseDrainPollInterval)
+	defer ticker.Stop()
+	for s.activeSSE.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
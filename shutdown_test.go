@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// serveListener serves s on a local port through ServeListener, as main
+// does, so Shutdown has a server to stop, and returns its address.
+func serveListener(t *testing.T, s *Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeListener(l) }()
+	t.Cleanup(func() {
+		s.httpServer.Close()
+		if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("ServeListener: %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func TestShutdownSendsCloseEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		tcpTuning bool
+		perWorker int
+	}{
+		{name: "response writer"},
+		{name: "hijacked", tcpTuning: true},
+		{name: "pooled", tcpTuning: true, perWorker: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.EnableTCPTuning = tt.tcpTuning
+			cfg.SSEClientsPerWorker = tt.perWorker
+			cfg.ShutdownSSETimeout = time.Second
+			s, _, _ := newTestServer(t, cfg)
+			addr := serveListener(t, s)
+
+			const clients = 3
+			readers := make([]*bufio.Reader, clients)
+			for i := range readers {
+				_, br := dialStream(t, addr)
+				readEvent(t, br)
+				readers[i] = br
+			}
+			waitFor(t, time.Second, func() bool { return s.TotalClients() == clients })
+
+			start := time.Now()
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- s.Shutdown() }()
+
+			for i, br := range readers {
+				ev := readEvent(t, br)
+				var data struct {
+					Reason     string `json:"reason"`
+					RetryAfter int    `json:"retry_after"`
+				}
+				if err := json.Unmarshal([]byte(ev.Data), &data); err != nil {
+					t.Fatalf("client %d: decoding %q: %v", i, ev.Data, err)
+				}
+				if ev.Event != "close" || data.Reason != "server_shutdown" || data.RetryAfter != 1 {
+					t.Errorf("client %d got %+v, want a close event for server_shutdown with retry_after 1", i, ev)
+				}
+				if elapsed := time.Since(start); elapsed < cfg.ShutdownSSETimeout {
+					t.Errorf("client %d: closed after %v, before the SSE grace period of %v", i, elapsed, cfg.ShutdownSSETimeout)
+				}
+				if _, err := br.ReadString('\n'); err == nil {
+					t.Errorf("client %d: stream still open after the close event", i)
+				}
+			}
+
+			select {
+			case err := <-shutdown:
+				if err != nil {
+					t.Errorf("Shutdown: %v", err)
+				}
+			case <-time.After(cfg.ShutdownSSETimeout + shutdownCloseMargin):
+				t.Fatal("Shutdown did not return")
+			}
+			if n := s.TotalClients(); n != 0 {
+				t.Errorf("%d clients still registered", n)
+			}
+		})
+	}
+}
+
+func TestContextCancelledOnShutdown(t *testing.T) {
+	s, _, _ := newTestServer(t, testConfig())
+	serveListener(t, s)
+
+	stopped := make(chan struct{})
+	go func() {
+		<-s.Context().Done()
+		close(stopped)
+	}()
+
+	if err := s.Context().Err(); err != nil {
+		t.Fatalf("Context().Err() before Shutdown = %v", err)
+	}
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("work on the server context still running after Shutdown")
+	}
+	if !errors.Is(s.Context().Err(), context.Canceled) {
+		t.Errorf("Context().Err() after Shutdown = %v, want context.Canceled", s.Context().Err())
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Done not closed after Shutdown")
+	}
+}
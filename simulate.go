@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	formatSimulated = "simulated"
+
+	// simulatedReadInterval is how often a simulated slow client takes
+	// one message off its buffer.
+	simulatedReadInterval = 10 * time.Second
+)
+
+type simulateSlowClientRequest struct {
+	Channel string `json:"channel"`
+	UserID  uint64 `json:"user_id"`
+}
+
+// adminSimulateSlowClientHandler registers a client with a one-message
+// buffer that reads one message every simulatedReadInterval, so drops
+// and backpressure can be observed without a misbehaving browser. It
+// stays connected until disconnected through /admin/disconnect or the
+// server shuts down.
+func (s *Server) adminSimulateSlowClientHandler(w http.ResponseWriter, r *http.Request) {
+	var req simulateSlowClientRequest
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, s.config.MaxPayloadBytes), &req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = defaultChannel
+	}
+	if verr := s.validateChannelName(req.Channel); verr != nil {
+		http.Error(w, verr.Detail, http.StatusBadRequest)
+		return
+	}
+
+	ch := make(chan *Frame, 1)
+	meta := &ClientMeta{
+		ConnID:      uuid.NewString(),
+		SessionID:   uuid.NewString(),
+		UserID:      req.UserID,
+		Channel:     req.Channel,
+		Format:      formatSimulated,
+		RemoteAddr:  formatSimulated,
+		ConnectedAt: s.clock.Now(),
+		kick:        make(chan struct{}),
+	}
+	meta.Logger = s.logger.With(
+		"user_id", meta.UserID,
+		"channel", meta.Channel,
+		"conn_id", meta.ConnID,
+		"simulated", true,
+	)
+
+	s.clients.Add(ch, meta)
+	go s.runSlowClient(ch, meta)
+
+	meta.Logger.Info("Simulated slow client connected", "session_id", meta.SessionID)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"session_id": meta.SessionID,
+		"conn_id":    meta.ConnID,
+		"channel":    meta.Channel,
+		"user_id":    meta.UserID,
+	})
+}
+
+// runSlowClient drains ch at the simulated client's pace until it is
+// disconnected or the server shuts down.
+func (s *Server) runSlowClient(ch chan *Frame, meta *ClientMeta) {
+	ticker := time.NewTicker(simulatedReadInterval)
+	defer ticker.Stop()
+
+	defer func() {
+		s.clients.Remove(ch)
+		meta.Logger.Info("Simulated slow client disconnected",
+			"messages_sent", meta.messagesSent.Load())
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case <-ch:
+				meta.messagesSent.Add(1)
+			default:
+			}
+		case <-meta.kick:
+			return
+		case <-s.Done():
+			return
+		}
+	}
+}
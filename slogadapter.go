@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+)
+
+// slogAdapter lets a stdlib *log.Logger write through slog, so errors
+// net/http reports on http.Server.ErrorLog (TLS handshake failures,
+// panics in handlers, Accept errors) come out as structured JSON like
+// everything else. Create the log.Logger with no prefix and flags 0; the
+// timestamp comes from slog.
+type slogAdapter struct {
+	logger *slog.Logger
+}
+
+func (a slogAdapter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+	a.logger.Error(msg, "component", "net/http")
+	return len(p), nil
+}
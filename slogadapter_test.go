@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer that a logger on another goroutine may
+// write to while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSlogAdapter(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "trailing newline", input: "http: superfluous response.WriteHeader call\n", want: `"msg":"http: superfluous response.WriteHeader call"`},
+		{name: "no newline", input: "http: Accept error", want: `"msg":"http: Accept error"`},
+		{name: "inner newline kept", input: "line one\nline two\n", want: `"msg":"line one\nline two"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			a := slogAdapter{logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+
+			n, err := a.Write([]byte(tt.input))
+			if err != nil || n != len(tt.input) {
+				t.Fatalf("Write = %d, %v; want %d, nil", n, err, len(tt.input))
+			}
+			for _, want := range []string{tt.want, `"level":"ERROR"`, `"component":"net/http"`} {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("log %s does not contain %s", buf.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSHandshakeErrorLoggedToSlog(t *testing.T) {
+	// Borrow httptest's self-signed certificate for the server's listener.
+	certServer := httptest.NewUnstartedServer(http.NotFoundHandler())
+	certServer.StartTLS()
+	certs := certServer.TLS.Certificates
+	certServer.Close()
+
+	var stderr bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&stderr)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	var buf lockedBuffer
+	s, _, _ := newTestServer(t, testConfig(), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeListener(tls.NewListener(l, &tls.Config{Certificates: certs})) }()
+	t.Cleanup(func() {
+		s.httpServer.Close()
+		if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("ServeListener: %v", err)
+		}
+	})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bytes that are neither a TLS record nor an HTTP request fail the
+	// handshake, which net/http reports through ErrorLog.
+	conn.Write([]byte("\x00\x00\x00\x00\x00not tls"))
+	conn.Close()
+
+	waitFor(t, time.Second, func() bool { return strings.Contains(buf.String(), "TLS handshake error") })
+	for _, want := range []string{`"level":"ERROR"`, `"component":"net/http"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %s does not contain %s", buf.String(), want)
+		}
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("standard logger got %q", stderr.String())
+	}
+}
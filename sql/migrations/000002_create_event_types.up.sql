@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS event_types (
+    name       TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
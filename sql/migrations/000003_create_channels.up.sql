@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS channels (
+    name       TEXT PRIMARY KEY,
+    created_by BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO channels (name) VALUES ('default') ON CONFLICT DO NOTHING;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS pending_events (
+    id         BIGSERIAL PRIMARY KEY,
+    event_id   UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    message    JSONB NOT NULL,
+    status     TEXT NOT NULL DEFAULT 'pending',
+    attempts   INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS pending_events_status_idx ON pending_events (status, id);
@@ -0,0 +1,3 @@
+ALTER TABLE pending_events DROP COLUMN IF EXISTS channel;
+
+DROP TABLE IF EXISTS routing_rules;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS routing_rules (
+    id                 BIGSERIAL PRIMARY KEY,
+    event_type_pattern TEXT NOT NULL,
+    target_channel     TEXT NOT NULL,
+    priority           INT NOT NULL DEFAULT 0
+);
+
+ALTER TABLE pending_events ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'default';
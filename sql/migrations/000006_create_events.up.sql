@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS events (
+    id         BIGSERIAL PRIMARY KEY,
+    event_id   UUID NOT NULL UNIQUE,
+    channel    TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    message    JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS events_channel_id_idx ON events (channel, id);
+CREATE INDEX IF NOT EXISTS events_created_at_idx ON events (created_at);
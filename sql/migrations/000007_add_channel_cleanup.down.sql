@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS channels_cleanup_at_idx;
+
+ALTER TABLE channels DROP COLUMN IF EXISTS cleanup_at;
+ALTER TABLE channels DROP COLUMN IF EXISTS is_static;
@@ -0,0 +1,6 @@
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS is_static BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS cleanup_at TIMESTAMPTZ;
+
+UPDATE channels SET is_static = TRUE WHERE name = 'default';
+
+CREATE INDEX IF NOT EXISTS channels_cleanup_at_idx ON channels (cleanup_at) WHERE cleanup_at IS NOT NULL;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS event_schemas (
+    channel    TEXT PRIMARY KEY REFERENCES channels (name) ON DELETE CASCADE,
+    schema     JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
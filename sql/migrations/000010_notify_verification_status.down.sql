@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS users_verification_status_notify ON users;
+
+DROP FUNCTION IF EXISTS peeple_notify_verification_status();
@@ -0,0 +1,24 @@
+-- users belongs to the accounts service, whose migrations must have run
+-- first. Without the table there is nothing to notify on, so fail with a
+-- clear message rather than leave the auth cache silently stale.
+DO $$
+BEGIN
+    IF to_regclass('users') IS NULL THEN
+        RAISE EXCEPTION 'migration 000010 needs the users table; run the accounts service migrations first';
+    END IF;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION peeple_notify_verification_status() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('peeple_auth_invalidate', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_verification_status_notify ON users;
+CREATE TRIGGER users_verification_status_notify
+    AFTER UPDATE OF verification_status ON users
+    FOR EACH ROW
+    WHEN (OLD.verification_status IS DISTINCT FROM NEW.verification_status)
+    EXECUTE FUNCTION peeple_notify_verification_status();
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS channel_members (
+    channel    TEXT NOT NULL REFERENCES channels (name) ON DELETE CASCADE,
+    user_id    BIGINT NOT NULL,
+    role       TEXT NOT NULL CHECK (role IN ('owner', 'publisher', 'subscriber')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (channel, user_id)
+);
+
+INSERT INTO channel_members (channel, user_id, role)
+SELECT name, created_by, 'owner' FROM channels WHERE created_by IS NOT NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+ALTER TABLE pending_events DROP COLUMN IF EXISTS deliver_at;
@@ -0,0 +1 @@
+ALTER TABLE pending_events ADD COLUMN IF NOT EXISTS deliver_at TIMESTAMPTZ;
@@ -0,0 +1 @@
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS retention_days INTEGER CHECK (retention_days >= 0);
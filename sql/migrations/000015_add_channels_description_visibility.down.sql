@@ -0,0 +1,4 @@
+ALTER TABLE channels
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS visibility,
+    DROP COLUMN IF EXISTS description;
@@ -0,0 +1,4 @@
+ALTER TABLE channels
+    ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS visibility  TEXT NOT NULL DEFAULT 'public' CHECK (visibility IN ('public', 'private')),
+    ADD COLUMN IF NOT EXISTS updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW();
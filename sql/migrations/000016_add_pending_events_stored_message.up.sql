@@ -0,0 +1 @@
+ALTER TABLE pending_events ADD COLUMN IF NOT EXISTS stored_message JSONB;
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sseHandler streams events to one client. Disconnects are detected only
+// through r.Context(), which net/http cancels when the connection closes;
+// the deprecated http.CloseNotifier must not be used here.
+//
+// A stream normally runs on the goroutine net/http started for the
+// request. Hijacked streams no longer need it once their headers are
+// written, so with a stream pool they are handed to a shared worker and
+// the handler returns; the worker notices disconnects by failed writes.
+func (s *Server) sseHandler(w http.ResponseWriter, r *http.Request) {
+	// The count can run slightly past the limit when many clients connect
+	// at once; it only has to stop the instance being overwhelmed.
+	if limit := s.config.MaxSSEConnections; limit > 0 {
+		if n := s.TotalClients(); n >= limit {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.config.SSEOverloadRetry.Seconds())))
+			w.Header().Set("X-Connected-Clients", strconv.Itoa(n))
+			http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if r.URL.Query().Get("transport") == transportLongPoll {
+		s.longPollHandler(w, r)
+		return
+	}
+
+	// ?format=ndjson streams one JSON object per line for clients that
+	// would rather not parse SSE. Everything else about the stream is the
+	// same.
+	format := formatSSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	if r.URL.Query().Get("format") == formatNDJSON {
+		format = formatNDJSON
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Del("Content-Length")
+
+	// Some proxies only stream responses they can see are chunked, so make
+	// it explicit instead of relying on net/http's implicit behaviour.
+	// HTTP/2 streams in DATA frames and forbids the header, and HTTP/1.0
+	// has no chunked encoding, so it is only set for HTTP/1.1.
+	if r.ProtoMajor == 1 && r.ProtoMinor == 1 {
+		w.Header().Set("Transfer-Encoding", "chunked")
+	}
+	// nginx buffers proxied responses by default, which holds events back
+	// until the buffer fills. The header turns that off for this response
+	// and is ignored by everything else, so it is sent whenever a proxy
+	// may be in front, not only when one is configured.
+	if s.config.NginxSSEProxyMode || s.config.BehindProxy || viaProxy(r) {
+		w.Header().Set("X-Accel-Buffering", "no")
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		channel = defaultChannel
+	}
+	if verr := s.validateChannelName(channel); verr != nil {
+		http.Error(w, verr.Detail, http.StatusBadRequest)
+		return
+	}
+
+	// Only HTTP/1.x streams are gzipped, and only for clients that
+	// accept it.
+	compress := r.ProtoMajor == 1 && acceptsGzip(r)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	// HEAD answers with the headers a stream would get and stops there, so
+	// load balancer checks do not register a client.
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// With ENABLE_TCP_TUNING the stream is written to the raw connection
+	// so socket options can be set on it. Anything that stops the hijack
+	// leaves the regular response writer in place.
+	var (
+		out io.Writer = w
+		hs  *hijackedStream
+		gs  *gzipStream
+	)
+	if s.config.EnableTCPTuning && r.ProtoMajor == 1 && r.ProtoMinor == 1 {
+		var err error
+		if hs, err = s.hijackStream(w, r); err != nil {
+			s.logger.Debug("Not hijacking SSE connection", "error", err)
+		} else {
+			out, flusher = hs, hs
+			r = r.WithContext(hs.ctx)
+		}
+	}
+	if compress {
+		gs = newGzipStream(out, flusher)
+		out, flusher = gs, gs
+	}
+
+	messageChan := make(chan *Frame, 10)
+
+	meta := &ClientMeta{
+		ConnID:      uuid.NewString(),
+		SessionID:   uuid.NewString(),
+		Channel:     channel,
+		Format:      format,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: s.clock.Now(),
+		limiter:     s.newClientBucket(),
+		kick:        make(chan struct{}),
+	}
+	s.identifyClient(r, meta)
+	if prev, err := uuid.Parse(r.Header.Get("X-Session-ID")); err == nil {
+		meta.ResumedFrom = prev.String()
+	}
+	logger := meta.Logger
+
+	// A hijacked stream goes to a pool worker, which only looks at it when
+	// woken through meta.wake.
+	var worker *streamWorker
+	if hs != nil && s.streams != nil {
+		worker = s.streams.reserve()
+		meta.wake = worker.wake
+	}
+
+	s.clients.Add(messageChan, meta)
+
+	// Only headers picked out here are logged; Authorization and Cookie
+	// carry credentials and must never be.
+	logger.Info("New SSE client connected",
+		"session_id", meta.SessionID,
+		"resumed_from", meta.ResumedFrom,
+		"user_agent", r.Header.Get("User-Agent"))
+
+	st := &sseStream{
+		s:       s,
+		meta:    meta,
+		ch:      messageChan,
+		bw:      bufio.NewWriter(out),
+		flusher: flusher,
+		format:  format,
+		hs:      hs,
+		gs:      gs,
+		// ?pretty=true indents JSON data for developers reading the
+		// stream in a terminal, so it is only honoured with
+		// ENABLE_DEBUG_UI. NDJSON needs one line per event and is never
+		// indented.
+		pretty: s.config.EnableDebugUI && format == formatSSE && r.URL.Query().Get("pretty") == "true",
+		// Ping events keep idle streams from being closed by proxies and
+		// let clients measure latency through /pong. Clients that find
+		// them noisy can opt out with ?heartbeat=false.
+		heartbeat: s.config.HeartbeatInterval > 0 && r.URL.Query().Get("heartbeat") != "false",
+	}
+
+	lastEventID, err := s.store.LastID(r.Context(), meta.Channel)
+	if err != nil {
+		logger.Warn("Failed to look up last event ID", "error", err)
+	}
+
+	initMsg, _ := jsonMarshal(map[string]any{
+		"status":        "connected",
+		"server_time":   meta.ConnectedAt.UTC().Format(time.RFC3339),
+		"session_id":    meta.SessionID,
+		"retry_ms":      s.config.SSERetry.Milliseconds(),
+		"channels":      []string{meta.Channel},
+		"user_id":       meta.UserID,
+		"last_event_id": lastEventID,
+	})
+	// The ID also becomes the stream's Last-Event-ID until an event
+	// replaces it.
+	connected := &Frame{Data: initMsg, Retry: s.config.SSERetry}
+	if lastEventID > 0 {
+		connected.ID = strconv.FormatInt(lastEventID, 10)
+	}
+	st.write(connected)
+	st.flusher.Flush()
+
+	if worker != nil {
+		s.streams.adopt(worker, st)
+		return
+	}
+	if hs != nil {
+		hs.watch()
+	}
+	defer st.end()
+
+	var heartbeat <-chan time.Time
+	if st.heartbeat {
+		ticker := time.NewTicker(s.config.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case frame := <-messageChan:
+			if !s.paceClient(r.Context(), meta) {
+				return
+			}
+			st.send(frame)
+		case t := <-heartbeat:
+			st.ping(t)
+		case <-meta.kick:
+			st.drain()
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.sseClosed:
+			return
+		}
+	}
+}
+
+// sseStream is the writing side of one SSE connection. The handler's loop
+// drives it, or a streamWorker once a hijacked stream has been handed to
+// the pool; either way only one goroutine uses it at a time.
+type sseStream struct {
+	s       *Server
+	meta    *ClientMeta
+	ch      chan *Frame
+	bw      *bufio.Writer
+	flusher http.Flusher
+	format  string
+	pretty  bool
+	// heartbeat is false for clients that asked for ?heartbeat=false.
+	heartbeat bool
+
+	// hs and gs are set for hijacked and gzipped streams and closed, in
+	// that order reversed, when the stream ends.
+	hs *hijackedStream
+	gs *gzipStream
+
+	// held is a frame a pooled stream's limiter is holding back until
+	// heldUntil.
+	held      *Frame
+	heldUntil time.Time
+}
+
+// write encodes f into the stream's buffer without flushing it.
+func (st *sseStream) write(f *Frame) {
+	if st.pretty {
+		f = f.indented()
+	}
+	writeFrame(st.bw, f, st.format)
+}
+
+// send writes a broadcast frame and flushes it to the client.
+func (st *sseStream) send(f *Frame) {
+	st.write(f)
+	st.flusher.Flush()
+	st.meta.messagesSent.Add(1)
+}
+
+func (st *sseStream) ping(t time.Time) {
+	ping, _ := jsonMarshal(map[string]int64{"server_time": t.UnixMilli()})
+	st.write(&Frame{Event: "ping", Data: ping})
+	st.flusher.Flush()
+}
+
+// drain writes what was queued before a kick, such as a logout event, so
+// the stream can end after it.
+func (st *sseStream) drain() {
+	if st.held != nil {
+		st.write(st.held)
+		st.held = nil
+		st.meta.messagesSent.Add(1)
+	}
+	for range len(st.ch) {
+		st.write(<-st.ch)
+		st.meta.messagesSent.Add(1)
+	}
+	st.flusher.Flush()
+}
+
+// failed reports whether writing to a hijacked connection has failed,
+// which is how a pooled stream learns that its client went away.
+func (st *sseStream) failed() bool {
+	return st.hs != nil && st.hs.err != nil
+}
+
+// end unregisters the client and closes the stream, first telling the
+// client to reconnect later if the server is shutting down.
+func (st *sseStream) end() {
+	s := st.s
+	if s.ctx.Err() != nil {
+		s.writeCloseEvent(st.bw, st.flusher, st.format, st.meta.Logger)
+	}
+	s.clients.Remove(st.ch)
+	if st.gs != nil {
+		st.gs.close()
+	}
+	if st.hs != nil {
+		st.hs.close()
+	}
+	st.meta.Logger.Info("SSE client disconnected",
+		"duration_s", int64(s.clock.Now().Sub(st.meta.ConnectedAt).Seconds()),
+		"messages_sent", st.meta.messagesSent.Load())
+}
+
+// identifyClient sets meta's UserID from the request's claims and gives
+// it a logger. Anonymous clients are logged without user fields rather
+// than as user 0.
+func (s *Server) identifyClient(r *http.Request, meta *ClientMeta) {
+	attrs := []any{
+		"remote_addr", meta.RemoteAddr,
+		"channel", meta.Channel,
+		"conn_id", meta.ConnID,
+	}
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		meta.UserID = claims.EffectiveUserID()
+		attrs = append(attrs, "user_id", meta.UserID)
+		if claims.Subject != "" {
+			attrs = append(attrs, "sub", claims.Subject)
+		}
+	}
+	meta.Logger = s.logger.With(attrs...)
+}
+
+// paceClient waits until meta's limiter allows another event. It returns
+// false if the client or the server went away in the meantime.
+func (s *Server) paceClient(ctx context.Context, meta *ClientMeta) bool {
+	if meta.limiter == nil {
+		return true
+	}
+	wait := meta.limiter.reserve(s.clock.Now())
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-s.sseClosed:
+		return false
+	}
+}
+
+const (
+	formatSSE    = "sse"
+	formatNDJSON = "ndjson"
+)
+
+// writeFrame writes f to bw in the client's stream format.
+func writeFrame(bw *bufio.Writer, f *Frame, format string) (int64, error) {
+	if format == formatNDJSON {
+		return f.WriteNDJSON(bw)
+	}
+	return f.WriteTo(bw)
+}
+
+// writeCloseEvent tells a client the server is going away and how long to
+// wait before reconnecting. The retry field makes EventSource itself wait.
+// The client may already be gone, so a failed write, or a panic from a
+// ResponseWriter that does not expect it, is only logged.
+func (s *Server) writeCloseEvent(bw *bufio.Writer, flusher http.Flusher, format string, logger *slog.Logger) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Debug("Could not send close event", "panic", p)
+		}
+	}()
+
+	retryAfter := s.config.ShutdownSSETimeout
+	data, _ := jsonMarshal(map[string]any{
+		"reason":      "server_shutdown",
+		"retry_after": int64(retryAfter.Seconds()),
+	})
+	if _, err := writeFrame(bw, &Frame{Event: "close", Data: data, Retry: retryAfter}, format); err != nil {
+		logger.Debug("Could not send close event", "error", err)
+		return
+	}
+	flusher.Flush()
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sseEvent is one event as a client parses it off the stream.
+type sseEvent struct {
+	Event string
+	ID    string
+	Data  string
+}
+
+// startServer serves s on a local port for the duration of the test.
+func startServer(t testing.TB, s *Server) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// openStream connects to target on ts, with token when it is not empty,
+// and returns the response. The body is closed when the test ends, before
+// the server is.
+func openStream(t testing.TB, ts *httptest.Server, target, token string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readEvent reads the next event from br, joining data lines with
+// newlines as EventSource does.
+func readEvent(t testing.TB, br *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var (
+		ev   sseEvent
+		data []string
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if data == nil && ev.Event == "" {
+				continue
+			}
+			ev.Data = strings.Join(data, "\n")
+			return ev
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Event = value
+		case "id":
+			ev.ID = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
+
+func TestEventsAuthIsOptional(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      func(t *testing.T) string
+		verified   bool
+		wantStatus int
+		wantUserID string
+	}{
+		{
+			name:       "anonymous",
+			token:      func(*testing.T) string { return "" },
+			wantStatus: http.StatusOK,
+			wantUserID: `"user_id":0`,
+		},
+		{
+			name:       "token",
+			token:      func(t *testing.T) string { return signToken(t, &Claims{UserID: 7}) },
+			wantStatus: http.StatusOK,
+			wantUserID: `"user_id":7`,
+		},
+		{
+			name:       "token of a user who submitted verification",
+			token:      func(t *testing.T) string { return signToken(t, &Claims{UserID: 7}) },
+			verified:   true,
+			wantStatus: http.StatusOK,
+			wantUserID: `"user_id":7`,
+		},
+		{
+			name:       "invalid token",
+			token:      func(*testing.T) string { return "not-a-jwt" },
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, _ := newTestServer(t, testConfig())
+			fake.setHandler(func(q fakeQuery) fakeResult {
+				if strings.Contains(q.SQL, "verification_status") {
+					return fakeRow(tt.verified)
+				}
+				return fakeResult{}
+			})
+			ts := startServer(t, s)
+
+			resp := openStream(t, ts, "/events", tt.token(t))
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			ev := readEvent(t, bufio.NewReader(resp.Body))
+			if !strings.Contains(ev.Data, `"status":"connected"`) || !strings.Contains(ev.Data, tt.wantUserID) {
+				t.Errorf("connected event = %s, want %s", ev.Data, tt.wantUserID)
+			}
+		})
+	}
+}
+
+func TestIdentifyClientLogsUserOnlyWithClaims(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   *Claims
+		want     []string
+		dontWant []string
+	}{
+		{
+			name:     "anonymous",
+			dontWant: []string{`"user_id"`, `"sub"`},
+		},
+		{
+			name:   "user_id claim",
+			claims: &Claims{UserID: 7},
+			want:   []string{`"user_id":7`},
+		},
+		{
+			name:   "sub claim",
+			claims: &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "42"}},
+			want:   []string{`"user_id":42`, `"sub":"42"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			s, _, _ := newTestServer(t, testConfig(), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+			r := httptest.NewRequest(http.MethodGet, "/events", nil)
+			if tt.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), claimsKey, tt.claims))
+			}
+			meta := &ClientMeta{Channel: defaultChannel}
+			s.identifyClient(r, meta)
+			meta.Logger.Info("test")
+
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("log %s does not contain %s", buf.String(), want)
+				}
+			}
+			for _, bad := range tt.dontWant {
+				if strings.Contains(buf.String(), bad) {
+					t.Errorf("log %s contains %s", buf.String(), bad)
+				}
+			}
+		})
+	}
+}
+
+// waitFor polls cond every millisecond until it holds or timeout passes.
+func waitFor(t testing.TB, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %v", timeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSSEHandlerExitsOnContextCancel(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"sse", "/events"},
+		{"ndjson", "/events?format=ndjson"},
+		{"no heartbeat", "/events?heartbeat=false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.HeartbeatInterval = time.Hour
+			s, _, _ := newTestServer(t, cfg)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil).WithContext(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				s.sseHandler(httptest.NewRecorder(), r)
+			}()
+			waitFor(t, time.Second, func() bool { return s.TotalClients() == 1 })
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(100 * time.Millisecond):
+				t.Fatal("sseHandler still running 100ms after its context was cancelled")
+			}
+			if n := s.TotalClients(); n != 0 {
+				t.Errorf("%d clients still registered after the handler returned", n)
+			}
+		})
+	}
+}
+
+// TestNoCloseNotifier keeps disconnect detection on r.Context(): the
+// deprecated http.CloseNotifier must not be used anywhere in the service.
+func TestNoCloseNotifier(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", name, err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "http" && sel.Sel.Name == "CloseNotifier" {
+				t.Errorf("%s uses http.CloseNotifier", fset.Position(sel.Pos()))
+			}
+			if sel.Sel.Name == "CloseNotify" {
+				t.Errorf("%s calls CloseNotify", fset.Position(sel.Pos()))
+			}
+			return true
+		})
+	}
+}
+
+func TestConnectedEventLastEventID(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []string
+		want    int64
+	}{
+		{"no history", nil, 0},
+		{"other channel only", []string{"other"}, 0},
+		{"newest of the channel", []string{"room", "room", "other"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestServer(t, testConfig())
+			for _, channel := range tt.history {
+				w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+					strings.NewReader(`{"channel":"`+channel+`","event_type":"notification","payload":{}}`)))
+				if w.Code != http.StatusOK {
+					t.Fatalf("trigger: status %d, body %s", w.Code, w.Body)
+				}
+			}
+
+			resp := openStream(t, startServer(t, s), "/events?channel=room", "")
+			ev := readEvent(t, bufio.NewReader(resp.Body))
+			var connected struct {
+				LastEventID *int64 `json:"last_event_id"`
+			}
+			if err := json.Unmarshal([]byte(ev.Data), &connected); err != nil {
+				t.Fatal(err)
+			}
+			if connected.LastEventID == nil || *connected.LastEventID != tt.want {
+				t.Errorf("connected event = %s, want last_event_id %d", ev.Data, tt.want)
+			}
+			wantID := ""
+			if tt.want > 0 {
+				wantID = strconv.FormatInt(tt.want, 10)
+			}
+			if ev.ID != wantID {
+				t.Errorf("connected event id = %q, want %q", ev.ID, wantID)
+			}
+		})
+	}
+}
+
+func TestSSEOverHTTP2(t *testing.T) {
+	tests := []struct {
+		name      string
+		http2     bool
+		wantProto int
+		wantTE    []string
+	}{
+		{name: "HTTP/1.1", wantProto: 1, wantTE: []string{"chunked"}},
+		{name: "HTTP/2", http2: true, wantProto: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestServer(t, testConfig())
+			ts := httptest.NewUnstartedServer(s)
+			ts.EnableHTTP2 = tt.http2
+			ts.StartTLS()
+			t.Cleanup(ts.Close)
+
+			transport := ts.Client().Transport.(*http.Transport).Clone()
+			transport.ForceAttemptHTTP2 = tt.http2
+			transport.DisableCompression = true
+			resp, err := (&http.Client{Transport: transport}).Get(ts.URL + "/events?channel=room")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { resp.Body.Close() })
+
+			if resp.ProtoMajor != tt.wantProto {
+				t.Fatalf("proto = %s, want major %d", resp.Proto, tt.wantProto)
+			}
+			if !slices.Equal(resp.TransferEncoding, tt.wantTE) {
+				t.Errorf("Transfer-Encoding = %q, want %q", resp.TransferEncoding, tt.wantTE)
+			}
+
+			br := bufio.NewReader(resp.Body)
+			if ev := readEvent(t, br); !strings.Contains(ev.Data, `"status":"connected"`) {
+				t.Fatalf("first event = %+v, want connected", ev)
+			}
+			w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+				strings.NewReader(`{"channel":"room","event_type":"notification","payload":{"text":"hi"}}`)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("trigger: status %d, body %s", w.Code, w.Body)
+			}
+			ev := readEvent(t, br)
+			if ev.Event != "notification" || !strings.Contains(ev.Data, `"text":"hi"`) {
+				t.Errorf("event = %+v, want the triggered notification", ev)
+			}
+		})
+	}
+}
+
+// countingRegistry counts the clients ever added to a registry, so a
+// test can tell that none was, not even briefly.
+type countingRegistry struct {
+	clientRegistry
+	adds *atomic.Int64
+}
+
+func (r countingRegistry) Add(ch chan *Frame, meta *ClientMeta) {
+	r.adds.Add(1)
+	r.clientRegistry.Add(ch, meta)
+}
+
+func TestHeadEvents(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		target          string
+		wantStatus      int
+		wantContentType string
+		wantAdds        int64
+	}{
+		{name: "sse", method: http.MethodHead, target: "/events", wantStatus: http.StatusOK, wantContentType: "text/event-stream"},
+		{name: "ndjson", method: http.MethodHead, target: "/events?format=ndjson", wantStatus: http.StatusOK, wantContentType: "application/x-ndjson"},
+		{name: "invalid channel", method: http.MethodHead, target: "/events?channel=" + strings.Repeat("x", 300), wantStatus: http.StatusBadRequest},
+		// GET shows the counting would catch a registration.
+		{name: "get", method: http.MethodGet, target: "/events", wantStatus: http.StatusOK, wantContentType: "text/event-stream", wantAdds: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestServer(t, testConfig())
+			var adds atomic.Int64
+			s.clients = countingRegistry{clientRegistry: s.clients, adds: &adds}
+			ts := startServer(t, s)
+
+			req, err := http.NewRequest(tt.method, ts.URL+tt.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantContentType != "" {
+				if got := resp.Header.Get("Content-Type"); got != tt.wantContentType {
+					t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+				}
+				if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+					t.Errorf("Cache-Control = %q, want no-cache", got)
+				}
+			}
+			if n := adds.Load(); n != tt.wantAdds {
+				t.Errorf("%s registered %d clients, want %d", tt.method, n, tt.wantAdds)
+			}
+		})
+	}
+}
+
+// TestPrettyEvents checks that ?pretty=true spreads an event's JSON over
+// several data: lines that still form a valid SSE frame, and only when
+// the debug UI is enabled.
+func TestPrettyEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		debugUI    bool
+		perWorker  int
+		target     string
+		wantPretty bool
+	}{
+		{name: "debug UI", debugUI: true, target: "/events?pretty=true", wantPretty: true},
+		{name: "pooled stream", debugUI: true, perWorker: 10, target: "/events?pretty=true", wantPretty: true},
+		{name: "debug UI off", target: "/events?pretty=true"},
+		{name: "not asked for", debugUI: true, target: "/events"},
+		{name: "ndjson", debugUI: true, target: "/events?format=ndjson&pretty=true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.EnableDebugUI = tt.debugUI
+			cfg.SSEClientsPerWorker = tt.perWorker
+			s, _, _ := newTestServer(t, cfg)
+			br := bufio.NewReader(openStream(t, startServer(t, s), tt.target, "").Body)
+			ndjson := strings.Contains(tt.target, "format=ndjson")
+
+			// The connected event comes first, spread over lines or not.
+			if ndjson {
+				if _, err := br.ReadString('\n'); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				readEvent(t, br)
+			}
+			w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+				strings.NewReader(`{"event_type":"notification","payload":{"text":"hi"}}`)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("trigger: status %d, body %s", w.Code, w.Body)
+			}
+
+			if ndjson {
+				line, err := br.ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !json.Valid([]byte(line)) || !strings.Contains(line, `"text":"hi"`) {
+					t.Errorf("line = %q, want the compact event", line)
+				}
+				return
+			}
+
+			var data []string
+			for {
+				line, err := br.ReadString('\n')
+				if err != nil {
+					t.Fatalf("reading event: %v", err)
+				}
+				line = strings.TrimSuffix(line, "\n")
+				if line == "" {
+					if data == nil {
+						continue
+					}
+					break
+				}
+				field, value, ok := strings.Cut(line, ": ")
+				switch {
+				case !ok:
+					t.Fatalf("line %q is not an SSE field", line)
+				case field == "data":
+					data = append(data, value)
+				case field != "event" && field != "id" && field != "retry":
+					t.Fatalf("line %q has unknown field %q", line, field)
+				}
+			}
+
+			joined := strings.Join(data, "\n")
+			if !json.Valid([]byte(joined)) {
+				t.Fatalf("data lines do not join into JSON: %q", joined)
+			}
+			if tt.wantPretty {
+				if len(data) < 3 || data[0] != "{" || !strings.HasPrefix(data[1], `  "`) {
+					t.Errorf("data = %q, want JSON indented by two spaces", data)
+				}
+			} else if len(data) != 1 {
+				t.Errorf("data = %q, want a single line", data)
+			}
+			var compact bytes.Buffer
+			json.Compact(&compact, []byte(joined))
+			if !strings.Contains(compact.String(), `"text":"hi"`) {
+				t.Errorf("data %q lost the payload", joined)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// dumpStacksOnSignal does nothing where SIGUSR1 does not exist.
+func (s *Server) dumpStacksOnSignal() {}
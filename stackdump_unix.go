@@ -0,0 +1,41 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+// dumpStacksOnSignal logs the stack of every goroutine each time the
+// process receives SIGUSR1, so a stuck or leaking instance can be
+// inspected without restarting it under GOTRACEBACK=all.
+func (s *Server) dumpStacksOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-sigs:
+			s.logger.Info("goroutine_dump", "goroutines", runtime.NumGoroutine(), "stack", string(allStacks()))
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// allStacks returns runtime.Stack for all goroutines, growing the buffer
+// until the dump fits.
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
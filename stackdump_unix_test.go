@@ -0,0 +1,53 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDumpStacksOnSignal(t *testing.T) {
+	// SIGUSR1 kills a process that does not handle it. Catching it here
+	// too keeps the test binary alive if a signal arrives before the
+	// server is listening.
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, syscall.SIGUSR1)
+	defer signal.Stop(caught)
+
+	var buf lockedBuffer
+	s, _, _ := newTestServer(t, testConfig(), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	done := make(chan struct{})
+	go func() {
+		s.dumpStacksOnSignal()
+		close(done)
+	}()
+
+	// The server may register after the first signal, so keep sending
+	// until a dump shows up.
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), `"msg":"goroutine_dump"`) {
+		if time.Now().After(deadline) {
+			t.Fatal("no goroutine dump logged after SIGUSR1")
+		}
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(buf.String(), "goroutine 1 ") {
+		t.Errorf("dump does not contain goroutine 1: %s", buf.String())
+	}
+
+	s.cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("dumpStacksOnSignal still running after the server stopped")
+	}
+}
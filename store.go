@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is one broadcast as kept in a MessageStore.
+type Event struct {
+	EventID   string
+	Channel   string
+	EventType string
+	Message   []byte
+
+	// CreatedAt and DeliveredAt are filled in by Get. DeliveredAt is zero
+	// until MarkDelivered has been called for the event.
+	CreatedAt   time.Time
+	DeliveredAt time.Time
+}
+
+// ErrEventNotFound is returned by MessageStore.Get for an unknown ID.
+var ErrEventNotFound = errors.New("event not found")
+
+// MessageStore keeps the history of broadcast events that handlers save
+// and resume from. The default stores them in the events table; another
+// backend can be passed to NewServer with WithMessageStore.
+type MessageStore interface {
+	// Save records e before it is broadcast.
+	Save(ctx context.Context, e Event) error
+	// GetSince returns up to limit events of channel saved after the one
+	// with ID afterID, oldest first. An empty afterID starts at the oldest
+	// event; an unknown one yields no events.
+	GetSince(ctx context.Context, channel, afterID string, limit int) ([]Event, error)
+	// Get returns the event with ID id, or ErrEventNotFound.
+	Get(ctx context.Context, id string) (Event, error)
+	// LastID returns the sequence number of the newest event of channel,
+	// the events table's MAX(id), or 0 when the channel has no history.
+	LastID(ctx context.Context, channel string) (int64, error)
+	// MarkDelivered records that the event with ID id has been broadcast.
+	MarkDelivered(ctx context.Context, id string) error
+}
+
+// WithMessageStore replaces the PostgreSQL events table as the store of
+// event history.
+func WithMessageStore(store MessageStore) Option {
+	return func(s *Server) {
+		s.store = store
+	}
+}
+
+// pgMessageStore is the MessageStore backed by the events table.
+type pgMessageStore struct {
+	db *sql.DB
+}
+
+func (p *pgMessageStore) Save(ctx context.Context, e Event) error {
+	_, err := p.db.ExecContext(ctx,
+		"INSERT INTO events (event_id, channel, event_type, message) VALUES ($1, $2, $3, $4)",
+		e.EventID, e.Channel, e.EventType, e.Message)
+	return err
+}
+
+func (p *pgMessageStore) GetSince(ctx context.Context, channel, afterID string, limit int) ([]Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var rows *sql.Rows
+	var err error
+	if afterID == "" {
+		rows, err = p.db.QueryContext(ctx, `
+			SELECT event_id, channel, event_type, message FROM events
+			WHERE channel = $1
+			ORDER BY id
+			LIMIT $2`, channel, limit)
+	} else {
+		// event_id is a UUID column; anything else cannot match.
+		if _, perr := uuid.Parse(afterID); perr != nil {
+			return nil, nil
+		}
+		rows, err = p.db.QueryContext(ctx, `
+			SELECT event_id, channel, event_type, message FROM events
+			WHERE channel = $1 AND id > (SELECT id FROM events WHERE event_id = $2)
+			ORDER BY id
+			LIMIT $3`, channel, afterID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.EventID, &e.Channel, &e.EventType, &e.Message); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (p *pgMessageStore) Get(ctx context.Context, id string) (Event, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return Event{}, ErrEventNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var (
+		e           Event
+		deliveredAt sql.NullTime
+	)
+	err := p.db.QueryRowContext(ctx, `
+		SELECT event_id, channel, event_type, message, created_at, delivered_at FROM events
+		WHERE event_id = $1`, id).
+		Scan(&e.EventID, &e.Channel, &e.EventType, &e.Message, &e.CreatedAt, &deliveredAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Event{}, ErrEventNotFound
+	}
+	e.DeliveredAt = deliveredAt.Time
+	return e, err
+}
+
+func (p *pgMessageStore) LastID(ctx context.Context, channel string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var id int64
+	err := p.db.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(id), 0) FROM events WHERE channel = $1", channel).Scan(&id)
+	return id, err
+}
+
+func (p *pgMessageStore) MarkDelivered(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx,
+		"UPDATE events SET delivered_at = NOW() WHERE event_id = $1 AND delivered_at IS NULL", id)
+	return err
+}
+
+// saveEvent records an event in the message store. History is
+// best-effort: callers log a failure and still broadcast.
+func (s *Server) saveEvent(ctx context.Context, eventID, channel, eventType string, msg []byte) error {
+	return s.store.Save(ctx, Event{EventID: eventID, Channel: channel, EventType: eventType, Message: msg})
+}
+
+// markDelivered records in the message store that eventID went out. Like
+// saving, it is best-effort and only logged on failure.
+func (s *Server) markDelivered(ctx context.Context, eventID string) {
+	if err := s.store.MarkDelivered(ctx, eventID); err != nil {
+		s.logger.Warn("Failed to mark event delivered", "event_id", eventID, "error", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLastID(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]driver.Value
+		want int64
+	}{
+		{"no history", [][]driver.Value{{int64(0)}}, 0},
+		{"newest event", [][]driver.Value{{int64(42)}}, 42},
+	}
+	for _, tt := range tests {
+		t.Run("pg/"+tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+				return fakeResult{Columns: []string{"coalesce"}, Rows: tt.rows}
+			})
+			got, err := (&pgMessageStore{db: db}).LastID(context.Background(), "room")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("LastID = %d, want %d", got, tt.want)
+			}
+			q := fake.Queries()[0]
+			if !strings.Contains(q.SQL, "COALESCE(MAX(id), 0)") || q.Args[0] != "room" {
+				t.Errorf("query = %s %v", q.SQL, q.Args)
+			}
+		})
+	}
+
+	t.Run("memory", func(t *testing.T) {
+		ctx := context.Background()
+		m := NewMemoryMessageStore()
+		if got, _ := m.LastID(ctx, "room"); got != 0 {
+			t.Errorf("LastID of an empty store = %d", got)
+		}
+		for _, e := range []Event{{EventID: "a", Channel: "room"}, {EventID: "b", Channel: "room"}, {EventID: "c", Channel: "other"}} {
+			if err := m.Save(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, _ := m.LastID(ctx, "room"); got != 2 {
+			t.Errorf("LastID = %d, want 2", got)
+		}
+	})
+}
+
+func TestMemoryMessageStoreGetSince(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryMessageStore()
+	for _, e := range []Event{
+		{EventID: "a", Channel: "room"},
+		{EventID: "b", Channel: "other"},
+		{EventID: "c", Channel: "room"},
+		{EventID: "d", Channel: "room"},
+	} {
+		if err := m.Save(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Save(ctx, Event{EventID: "a", Channel: "room"}); err == nil {
+		t.Error("saving a duplicate event ID succeeded")
+	}
+
+	tests := []struct {
+		name    string
+		channel string
+		afterID string
+		limit   int
+		want    []string
+	}{
+		{name: "from the start", channel: "room", limit: 10, want: []string{"a", "c", "d"}},
+		{name: "after an event", channel: "room", afterID: "a", limit: 10, want: []string{"c", "d"}},
+		{name: "after another channel's event", channel: "room", afterID: "b", limit: 10, want: []string{"c", "d"}},
+		{name: "limited", channel: "room", limit: 2, want: []string{"a", "c"}},
+		{name: "after the newest", channel: "room", afterID: "d", limit: 10},
+		{name: "unknown id", channel: "room", afterID: "x", limit: 10},
+		{name: "other channel", channel: "other", limit: 10, want: []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := m.GetSince(ctx, tt.channel, tt.afterID, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range events {
+				got = append(got, e.EventID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("GetSince = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryMessageStoreDelivered(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryMessageStore()
+	if _, err := m.Get(ctx, "a"); !errors.Is(err, ErrEventNotFound) {
+		t.Fatalf("Get of an unknown event: %v, want ErrEventNotFound", err)
+	}
+	if err := m.Save(ctx, Event{EventID: "a", Channel: "room", Message: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := m.Get(ctx, "a"); err != nil || !e.DeliveredAt.IsZero() {
+		t.Fatalf("Get before delivery = %+v, %v", e, err)
+	}
+	if err := m.MarkDelivered(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := m.Get(ctx, "a"); err != nil || e.DeliveredAt.IsZero() {
+		t.Errorf("Get after delivery = %+v, %v", e, err)
+	}
+}
+
+func TestLongPollSinceEventID(t *testing.T) {
+	s, _, _ := newTestServer(t, testConfig())
+	var ids []string
+	for _, channel := range []string{"room", "other", "room"} {
+		w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1,
+			strings.NewReader(`{"channel":"`+channel+`","event_type":"notification","payload":{"n":`+strconv.Itoa(len(ids))+`}}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("trigger: status %d, body %s", w.Code, w.Body)
+		}
+		var res struct {
+			EventID string `json:"event_id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, res.EventID)
+	}
+
+	tests := []struct {
+		name  string
+		since string
+		want  string
+	}{
+		{name: "after the first event", since: ids[0], want: `"n":2`},
+		{name: "after another channel's event", since: ids[1], want: `"n":2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s, authRequest(t, http.MethodGet,
+				"/events?transport=long_poll&channel=room&since_event_id="+tt.since, 1, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) || !strings.Contains(w.Body.String(), ids[2]) {
+				t.Errorf("body %s is not the event %s", w.Body, ids[2])
+			}
+		})
+	}
+}
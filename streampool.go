@@ -0,0 +1,240 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamPool runs hijacked SSE streams on shared worker goroutines instead
+// of one goroutine per connection. Each worker serves up to
+// SSEClientsPerWorker streams, so 100k clients need about a hundred
+// goroutines rather than 100k.
+//
+// Only hijacked streams, those of ENABLE_TCP_TUNING over HTTP/1.1, can be
+// pooled: a regular response ends when its handler returns, so it keeps
+// the handler's goroutine whatever SSEClientsPerWorker says.
+type streamPool struct {
+	s         *Server
+	perWorker int
+
+	mu      sync.Mutex
+	workers []*streamWorker
+	next    int
+}
+
+func newStreamPool(s *Server, perWorker int) *streamPool {
+	return &streamPool{s: s, perWorker: perWorker}
+}
+
+// reserve picks the worker that will serve a stream and counts the stream
+// against it. Workers are tried in round-robin order and the first with
+// room is picked; when all are full a new one is started. The caller sets
+// the client's wake to the worker's before registering it, then hands
+// the stream over with adopt.
+func (p *streamPool) reserve() *streamWorker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var w *streamWorker
+	for range len(p.workers) {
+		candidate := p.workers[p.next%len(p.workers)]
+		p.next++
+		if candidate.load.Load() < int64(p.perWorker) {
+			w = candidate
+			break
+		}
+	}
+	if w == nil {
+		w = &streamWorker{s: p.s, wake: make(chan struct{}, 1)}
+		p.workers = append(p.workers, w)
+		go w.run()
+	}
+	w.load.Add(1)
+	return w
+}
+
+// adopt hands st to w, reserved for it, which from then on writes to it
+// and ends it.
+func (p *streamPool) adopt(w *streamWorker, st *sseStream) {
+	// The stream keeps counting as active after its handler returns, so
+	// Shutdown waits for it.
+	p.s.activeSSE.Add(1)
+	st.hs.writeTimeout = pooledWriteTimeout
+	w.add(st)
+}
+
+// workerCount returns how many worker goroutines the pool has started.
+func (p *streamPool) workerCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.workers)
+}
+
+// streamWorker serves the streams a streamPool assigned it.
+type streamWorker struct {
+	s *Server
+	// load counts streams assigned to the worker, including ones it has
+	// not picked up yet.
+	load atomic.Int64
+
+	mu      sync.Mutex
+	pending []*sseStream
+	closed  bool
+	// wake is signalled when a stream is added and, through each client's
+	// ClientMeta.wake, when one of its streams has a frame queued, is
+	// kicked or is removed. It holds one signal, so a burst of broadcasts
+	// wakes the worker once.
+	wake chan struct{}
+}
+
+// add queues st for the worker. After the worker has shut down, st is
+// ended at once.
+func (w *streamWorker) add(st *sseStream) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		w.finish(st)
+		return
+	}
+	w.pending = append(w.pending, st)
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// finish ends st and releases its slot.
+func (w *streamWorker) finish(st *sseStream) {
+	st.end()
+	w.load.Add(-1)
+	w.s.activeSSE.Add(-1)
+}
+
+// pooledWriteTimeout is how long a write to a pooled stream may block.
+// A client that stops reading holds up every other stream of its worker
+// for that long once, and is then dropped.
+const pooledWriteTimeout = 2 * time.Second
+
+// run does for each of the worker's streams what sseHandler's loop does
+// for one, until the SSE grace period of a shutdown ends. Each wake-up
+// drains every stream without blocking, so its cost is one pass over the
+// worker's streams however many frames arrived. A client that went away
+// is noticed when a write to it fails, at the latest on the second
+// heartbeat after it left.
+func (w *streamWorker) run() {
+	s := w.s
+
+	var heartbeat <-chan time.Time
+	if s.config.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(s.config.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	// release fires when the earliest frame held back by a client's
+	// limiter may be written.
+	release := time.NewTimer(time.Hour)
+	release.Stop()
+	defer release.Stop()
+
+	var streams []*sseStream
+	for {
+		select {
+		case <-w.wake:
+			w.mu.Lock()
+			streams = append(streams, w.pending...)
+			w.pending = nil
+			w.mu.Unlock()
+		case t := <-heartbeat:
+			for _, st := range streams {
+				if st.heartbeat {
+					st.ping(t)
+				}
+			}
+		case <-release.C:
+			now := s.clock.Now()
+			for _, st := range streams {
+				if st.held != nil && !now.Before(st.heldUntil) {
+					st.send(st.held)
+					st.held = nil
+				}
+			}
+		case <-s.sseClosed:
+			w.mu.Lock()
+			w.closed = true
+			streams = append(streams, w.pending...)
+			w.pending = nil
+			w.mu.Unlock()
+			for _, st := range streams {
+				w.finish(st)
+			}
+			return
+		}
+
+		streams = slices.DeleteFunc(streams, func(st *sseStream) bool {
+			if w.serve(st) {
+				return false
+			}
+			w.finish(st)
+			return true
+		})
+		w.scheduleRelease(release, streams)
+	}
+}
+
+// serve writes what is queued for st without blocking and reports whether
+// the stream goes on. A kicked stream writes what was queued before the
+// kick and ends; one whose channel was closed, or whose client went away,
+// ends at once.
+func (w *streamWorker) serve(st *sseStream) bool {
+	select {
+	case <-st.meta.kick:
+		st.drain()
+		return false
+	default:
+	}
+	// A stream with a held frame takes no more until it is written.
+	for st.held == nil && !st.failed() {
+		select {
+		case frame, ok := <-st.ch:
+			if !ok {
+				return false
+			}
+			w.deliver(st, frame)
+		default:
+			return true
+		}
+	}
+	return !st.failed()
+}
+
+// deliver writes frame to st, or holds it back if the client's limiter
+// wants it to wait, as paceClient would.
+func (w *streamWorker) deliver(st *sseStream, frame *Frame) {
+	if st.meta.limiter != nil {
+		now := w.s.clock.Now()
+		if wait := st.meta.limiter.reserve(now); wait > 0 {
+			st.held, st.heldUntil = frame, now.Add(wait)
+			return
+		}
+	}
+	st.send(frame)
+}
+
+// scheduleRelease sets release to fire when the earliest held frame is due.
+func (w *streamWorker) scheduleRelease(release *time.Timer, streams []*sseStream) {
+	var next time.Time
+	for _, st := range streams {
+		if st.held != nil && (next.IsZero() || st.heldUntil.Before(next)) {
+			next = st.heldUntil
+		}
+	}
+	if next.IsZero() {
+		release.Stop()
+		return
+	}
+	release.Reset(max(next.Sub(w.s.clock.Now()), 0))
+}
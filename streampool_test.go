@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialStream opens /events on addr over a raw connection, so the client
+// side adds no goroutines, and returns a reader positioned at the body.
+func dialStream(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/events", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	return conn, bufio.NewReader(resp.Body)
+}
+
+func TestStreamPoolMultiplexesHijackedStreams(t *testing.T) {
+	const (
+		clients   = 30
+		perWorker = 10
+	)
+	cfg := testConfig()
+	cfg.EnableTCPTuning = true
+	cfg.SSEClientsPerWorker = perWorker
+	s, _, _ := newTestServer(t, cfg)
+	ts := startServer(t, s)
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	before := runtime.NumGoroutine()
+	readers := make([]*bufio.Reader, clients)
+	for i := range readers {
+		_, readers[i] = dialStream(t, addr)
+		if ev := readEvent(t, readers[i]); !strings.Contains(ev.Data, `"status":"connected"`) {
+			t.Fatalf("first event = %+v", ev)
+		}
+	}
+	waitFor(t, time.Second, func() bool { return s.TotalClients() == clients })
+
+	if got := s.streams.workerCount(); got != clients/perWorker {
+		t.Errorf("pool started %d workers for %d streams, want %d", got, clients, clients/perWorker)
+	}
+	// Each stream would otherwise keep the goroutine serving its request
+	// and one watching the connection.
+	if grown := runtime.NumGoroutine() - before; grown > clients/perWorker+5 {
+		t.Errorf("goroutines grew by %d for %d streams", grown, clients)
+	}
+
+	s.BroadcastBytes(defaultChannel, "notification", []byte(`{"n":1}`))
+	for i, br := range readers {
+		ev := readEvent(t, br)
+		if ev.Event != "notification" || ev.Data != `{"n":1}` {
+			t.Errorf("client %d got %+v", i, ev)
+		}
+	}
+}
+
+func TestStreamPoolEndsKickedAndClosedStreams(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnableTCPTuning = true
+	cfg.SSEClientsPerWorker = 10
+	cfg.ClientRateLimit = 0
+	s, _, _ := newTestServer(t, cfg)
+	ts := startServer(t, s)
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	kicked, kickedBody := dialStream(t, addr)
+	readEvent(t, kickedBody)
+	gone, goneBody := dialStream(t, addr)
+	readEvent(t, goneBody)
+	waitFor(t, time.Second, func() bool { return s.TotalClients() == 2 })
+
+	// Anonymous streams belong to user 0, so this kicks both; only the
+	// first is still reading.
+	gone.Close()
+	s.disconnectUser(t.Context(), 0, eventFrame("", logoutEventType, []byte(`{}`)))
+	if ev := readEvent(t, kickedBody); ev.Event != logoutEventType {
+		t.Errorf("kicked stream got %+v before closing, want the logout event", ev)
+	}
+	waitFor(t, time.Second, func() bool { return s.TotalClients() == 0 })
+	if n := s.activeSSE.Load(); n != 0 {
+		t.Errorf("activeSSE = %d after every stream ended", n)
+	}
+	kicked.Close()
+}
+
+// TestStreamPoolDeliversBursts checks that a worker woken once for many
+// queued frames writes all of them, in order, to every stream.
+func TestStreamPoolDeliversBursts(t *testing.T) {
+	const (
+		clients = 10
+		events  = 100
+	)
+	cfg := testConfig()
+	cfg.EnableTCPTuning = true
+	cfg.SSEClientsPerWorker = clients
+	cfg.BackpressureStrategy = BackpressureBlock
+	cfg.BackpressureTimeout = 5 * time.Second
+	s, _, _ := newTestServer(t, cfg)
+	addr := strings.TrimPrefix(startServer(t, s).URL, "http://")
+
+	readers := make([]*bufio.Reader, clients)
+	for i := range readers {
+		_, readers[i] = dialStream(t, addr)
+		readEvent(t, readers[i])
+	}
+	waitFor(t, time.Second, func() bool { return s.TotalClients() == clients })
+	if got := s.streams.workerCount(); got != 1 {
+		t.Fatalf("pool started %d workers, want 1", got)
+	}
+
+	for n := range events {
+		s.BroadcastBytes(defaultChannel, "notification", fmt.Appendf(nil, `{"n":%d}`, n))
+	}
+	for i, br := range readers {
+		for n := range events {
+			if ev := readEvent(t, br); ev.Data != fmt.Sprintf(`{"n":%d}`, n) {
+				t.Fatalf("client %d event %d = %+v", i, n, ev)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const strippedValue = `"[STRIPPED]"`
+
+// stripFields removes the top-level keys of a JSON object payload that
+// match one of fields, ignoring case. It returns the payload without
+// them, for broadcasting, and with their values replaced by
+// "[STRIPPED]", for storing, along with the keys it found. Payloads that
+// are not objects, or have nothing to strip, are returned unchanged.
+func stripFields(payload json.RawMessage, fields []string) (omitted, marked json.RawMessage, stripped []string) {
+	if len(fields) == 0 || len(payload) == 0 {
+		return payload, payload, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := jsonUnmarshal(payload, &obj); err != nil {
+		return payload, payload, nil
+	}
+
+	for key := range obj {
+		for _, f := range fields {
+			if strings.EqualFold(key, f) {
+				stripped = append(stripped, key)
+				break
+			}
+		}
+	}
+	if stripped == nil {
+		return payload, payload, nil
+	}
+
+	for _, key := range stripped {
+		obj[key] = json.RawMessage(strippedValue)
+	}
+	marked, _ = jsonMarshal(obj)
+	for _, key := range stripped {
+		delete(obj, key)
+	}
+	omitted, _ = jsonMarshal(obj)
+	return omitted, marked, stripped
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var traceContextPropagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// traceContext returns the W3C traceparent and tracestate of r, as
+// understood by the TraceContext propagator, for inclusion in an event
+// envelope. A missing or malformed traceparent yields nil.
+func traceContext(r *http.Request) map[string]string {
+	ctx := traceContextPropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	carrier := propagation.MapCarrier{}
+	traceContextPropagator.Inject(ctx, carrier)
+	if carrier.Get("traceparent") == "" {
+		return nil
+	}
+	return carrier
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracelogHandler adds trace_id and span_id to records logged with a
+// context that carries a valid OpenTelemetry span, so lines written with
+// InfoContext and friends inside a span can be joined to the trace.
+type tracelogHandler struct {
+	slog.Handler
+}
+
+func newTracelogHandler(h slog.Handler) *tracelogHandler {
+	return &tracelogHandler{Handler: h}
+}
+
+func (h *tracelogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *tracelogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &tracelogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *tracelogHandler) WithGroup(name string) slog.Handler {
+	return &tracelogHandler{Handler: h.Handler.WithGroup(name)}
+}
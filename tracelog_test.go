@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracelogHandler(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	inSpan := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	invalidSpan := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{}))
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		// with adds attributes to the logger through With.
+		with      bool
+		wantTrace bool
+	}{
+		{name: "active span", ctx: inSpan, wantTrace: true},
+		{name: "active span through With", ctx: inSpan, with: true, wantTrace: true},
+		{name: "no span", ctx: context.Background()},
+		{name: "invalid span", ctx: invalidSpan},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(newTracelogHandler(slog.NewJSONHandler(&buf, nil)))
+			if tt.with {
+				logger = logger.With("component", "test")
+			}
+			logger.InfoContext(tt.ctx, "hello")
+
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %s: %v", buf.Bytes(), err)
+			}
+			wantTraceID, wantSpanID := "", ""
+			if tt.wantTrace {
+				wantTraceID, wantSpanID = traceID.String(), spanID.String()
+			}
+			if got, _ := line["trace_id"].(string); got != wantTraceID {
+				t.Errorf("trace_id = %q, want %q", got, wantTraceID)
+			}
+			if got, _ := line["span_id"].(string); got != wantSpanID {
+				t.Errorf("span_id = %q, want %q", got, wantSpanID)
+			}
+			if tt.with && line["component"] != "test" {
+				t.Errorf("component = %v, want test", line["component"])
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TriggerRequest is the optional JSON body of POST /trigger. An empty
+// body broadcasts the original {"number":1} event. A text/plain body is
+// turned into a request carrying only Message, and a form-encoded body
+// into one with the same fields.
+type TriggerRequest struct {
+	Channel   string          `json:"channel,omitempty"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Message   string          `json:"message,omitempty"`
+	// DeliverAt schedules the event for later instead of broadcasting it
+	// now.
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+
+	// Async queues the event for the background worker instead of
+	// broadcasting it before returning. /trigger sets it from ?async=true.
+	Async bool `json:"-"`
+	// TraceContext is copied into the event envelope as trace_context.
+	TraceContext map[string]string `json:"-"`
+}
+
+const (
+	legacyEventType  = "trigger"
+	messageEventType = "message"
+)
+
+// triggerHandler decodes the request and hands it to the TriggerService,
+// translating the outcome into a response.
+func (s *Server) triggerHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := s.decodeTriggerRequest(w, r)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr):
+			http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, errInvalidGzip):
+			http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+		case errors.Is(err, errUnsupportedEncoding):
+			http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+		default:
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		}
+		return
+	}
+	// POST /channels/{name}/publish names the channel in the path, which
+	// takes precedence over the body.
+	if name := r.PathValue("name"); name != "" {
+		req.Channel = name
+	}
+	req.Async = r.URL.Query().Get("async") == "true"
+	req.TraceContext = traceContext(r)
+
+	res, err := s.triggers.Trigger(r.Context(), req)
+	var (
+		triggerErr *TriggerError
+		bpErr      *DroppedClientsError
+	)
+	switch {
+	case errors.As(err, &triggerErr):
+		body := map[string]any{"error": triggerErr.Code}
+		maps.Copy(body, triggerErr.Details)
+		writeJSON(w, http.StatusUnprocessableEntity, body)
+		return
+	case errors.Is(err, errInvalidPayload):
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	case errors.As(err, &bpErr):
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"error":     "clients_dropped",
+			"event_id":  res.EventID,
+			"user_ids":  bpErr.UserIDs,
+			"delivered": res.Delivered,
+			"dropped":   res.Dropped,
+		})
+		return
+	case err != nil:
+		s.logger.Error("Failed to trigger event", "channel", req.Channel, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if res.Status == triggerStatusDeduplicated {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"deduplicated":      true,
+			"original_event_id": res.OriginalEventID,
+		})
+		return
+	}
+
+	w.Header().Set("Location", routePrefix(r.Context())+"/v1/events/history/"+res.EventID)
+	switch res.Status {
+	case triggerStatusScheduled:
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"status":     res.Status,
+			"event_id":   res.EventID,
+			"deliver_at": res.DeliverAt.UTC().Format(time.RFC3339),
+		})
+	case triggerStatusQueued:
+		writeJSON(w, http.StatusAccepted, map[string]any{"status": res.Status, "event_id": res.EventID})
+	case triggerStatusThrottled:
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"status":         res.Status,
+			"event_id":       res.EventID,
+			"queue_position": res.QueuePosition,
+		})
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":    res.Status,
+			"event_id":  res.EventID,
+			"delivered": res.Delivered,
+			"dropped":   res.Dropped,
+		})
+	}
+}
+
+func (s *Server) decodeTriggerRequest(w http.ResponseWriter, r *http.Request) (TriggerRequest, error) {
+	req := TriggerRequest{EventType: legacyEventType}
+	body, err := limitedBody(w, r, s.config.MaxPayloadBytes)
+	if err != nil {
+		return req, err
+	}
+
+	switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+	case "text/plain":
+		text, err := io.ReadAll(body)
+		if err != nil {
+			return req, err
+		}
+		return TriggerRequest{Channel: defaultChannel, EventType: messageEventType, Message: string(text)}, nil
+
+	case "application/x-www-form-urlencoded":
+		r.Body = body
+		if err := r.ParseForm(); err != nil {
+			return req, err
+		}
+		if v := r.PostForm.Get("event_type"); v != "" {
+			req.EventType = v
+		}
+		req.Channel = r.PostForm.Get("channel")
+		if v := r.PostForm.Get("deliver_at"); v != "" {
+			at, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return req, err
+			}
+			req.DeliverAt = &at
+		}
+		// payload is meant to be JSON; anything else is sent as a message
+		// so shell scripts can post plain text.
+		if raw := r.PostForm.Get("payload"); raw != "" {
+			if json.Valid([]byte(raw)) {
+				req.Payload = json.RawMessage(raw)
+			} else {
+				req.Payload, _ = jsonMarshal(map[string]string{"message": raw})
+			}
+		}
+
+	default:
+		err := decodeJSON(body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return req, err
+		}
+	}
+
+	if req.EventType == "" {
+		req.EventType = legacyEventType
+	}
+	if req.Channel == "" {
+		req.Channel = defaultChannel
+	}
+	return req, nil
+}
+
+// formatDuration prints d without trailing zero units, so 720h comes out
+// as "720h" rather than "720h0m0s".
+func formatDuration(d time.Duration) string {
+	v := d.String()
+	if strings.HasSuffix(v, "m0s") {
+		v = strings.TrimSuffix(v, "0s")
+	}
+	if strings.HasSuffix(v, "h0m") {
+		v = strings.TrimSuffix(v, "0m")
+	}
+	return v
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTriggerPlainText(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxPayload  int64
+		wantStatus  int
+		wantMessage string
+	}{
+		{"plain text", "text/plain", "hello", 0, http.StatusOK, "hello"},
+		{"plain text with charset", "text/plain; charset=utf-8", "héllo", 0, http.StatusOK, "héllo"},
+		{"text containing quotes", "text/plain", `say "hi"`, 0, http.StatusOK, `say "hi"`},
+		{"over the size limit", "text/plain", strings.Repeat("x", 100), 10, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			if tt.maxPayload > 0 {
+				cfg.MaxPayloadBytes = tt.maxPayload
+			}
+			s, _, _ := newTestServer(t, cfg)
+			ch := addClients(s, 1, defaultChannel, 1)[0]
+
+			r := authRequest(t, http.MethodPost, "/trigger", 1, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := serve(s, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(ch) != 0 {
+					t.Error("rejected trigger was broadcast")
+				}
+				return
+			}
+
+			f := <-ch
+			if f.Event != messageEventType {
+				t.Errorf("event = %q, want %q", f.Event, messageEventType)
+			}
+			var got struct {
+				EventType string `json:"event_type"`
+				Message   string `json:"message"`
+				Timestamp int64  `json:"timestamp"`
+			}
+			if err := json.Unmarshal(f.Data, &got); err != nil {
+				t.Fatalf("event data %s is not JSON: %v", f.Data, err)
+			}
+			if got.Message != tt.wantMessage || got.EventType != messageEventType || got.Timestamp == 0 {
+				t.Errorf("event data = %s", f.Data)
+			}
+		})
+	}
+}
+
+func TestTriggerDedup(t *testing.T) {
+	const (
+		hi    = `{"event_type":"notification","payload":{"text":"hi"}}`
+		bye   = `{"event_type":"notification","payload":{"text":"bye"}}`
+		other = `{"channel":"other","event_type":"notification","payload":{"text":"hi"}}`
+	)
+	steps := []struct {
+		name    string
+		advance time.Duration
+		body    string
+		// dupOf is the step whose event this one repeats, or -1.
+		dupOf int
+	}{
+		{name: "first", body: hi, dupOf: -1},
+		{name: "repeat within the window", advance: 500 * time.Millisecond, body: hi, dupOf: 0},
+		{name: "other payload", body: bye, dupOf: -1},
+		{name: "other channel", body: other, dupOf: -1},
+		{name: "repeat just before expiry", advance: 499 * time.Millisecond, body: hi, dupOf: 0},
+		{name: "repeat after the window", advance: time.Millisecond, body: hi, dupOf: -1},
+		{name: "repeat of the new event", advance: 999 * time.Millisecond, body: hi, dupOf: 5},
+	}
+
+	cfg := testConfig()
+	cfg.DedupWindow = time.Second
+	s, _, clock := newTestServer(t, cfg)
+	addClients(s, 1, "other", 10)
+	ch := addClients(s, 1, defaultChannel, 10)[0]
+
+	ids := make([]string, len(steps))
+	for i, st := range steps {
+		clock.Advance(st.advance)
+		w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1, strings.NewReader(st.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", st.name, w.Code, w.Body)
+		}
+		var res struct {
+			EventID         string `json:"event_id"`
+			Deduplicated    bool   `json:"deduplicated"`
+			OriginalEventID string `json:"original_event_id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = res.EventID
+
+		if st.dupOf < 0 {
+			if res.Deduplicated || res.EventID == "" {
+				t.Errorf("%s: got %s, want a new event", st.name, w.Body)
+			}
+			continue
+		}
+		if !res.Deduplicated || res.OriginalEventID != ids[st.dupOf] {
+			t.Errorf("%s: got %s, want a duplicate of %s", st.name, w.Body, ids[st.dupOf])
+		}
+	}
+
+	// Only the new events on the default channel were broadcast: first,
+	// other payload and the repeat after the window.
+	if n := len(ch); n != 3 {
+		t.Errorf("%d events broadcast, want 3", n)
+	}
+}
+
+func TestTriggerScheduleBounds(t *testing.T) {
+	const maxAdvance = 30 * 24 * time.Hour
+	tests := []struct {
+		name       string
+		offset     time.Duration
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "just in the past", offset: -time.Millisecond, wantStatus: http.StatusUnprocessableEntity, wantBody: `"error":"schedule_in_past"`},
+		{name: "now", offset: 0, wantStatus: http.StatusAccepted, wantBody: `"status":"scheduled"`},
+		{name: "tomorrow", offset: 24 * time.Hour, wantStatus: http.StatusAccepted, wantBody: `"status":"scheduled"`},
+		{name: "at the limit", offset: maxAdvance, wantStatus: http.StatusAccepted, wantBody: `"status":"scheduled"`},
+		{name: "just past the limit", offset: maxAdvance + time.Millisecond, wantStatus: http.StatusUnprocessableEntity, wantBody: `"max_advance":"720h"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ScheduleMaxAdvance = maxAdvance
+			s, fake, clock := newTestServer(t, cfg)
+			ch := addClients(s, 1, defaultChannel, 1)[0]
+
+			deliverAt := clock.Now().Add(tt.offset).Format(time.RFC3339Nano)
+			w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1, strings.NewReader(
+				`{"event_type":"notification","payload":{},"deliver_at":"`+deliverAt+`"}`)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body %s does not contain %s", w.Body, tt.wantBody)
+			}
+			if len(ch) != 0 {
+				t.Error("scheduled event was broadcast at once")
+			}
+			scheduled := fake.countQueries("INSERT INTO pending_events")
+			if want := tt.wantStatus == http.StatusAccepted; (scheduled == 1) != want {
+				t.Errorf("%d events queued, want queued = %v", scheduled, want)
+			}
+		})
+	}
+}
+
+func TestTriggerGzipBody(t *testing.T) {
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.Bytes()
+	}
+	event := `{"event_type":"notification","payload":{"text":"hi"}}`
+	// Far over the limit once decoded, far under it compressed.
+	large := `{"event_type":"notification","payload":{"text":"` + strings.Repeat("x", 64<<10) + `"}}`
+	full := gzipped(event)
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+	}{
+		{name: "gzip", encoding: "gzip", body: full, wantStatus: http.StatusOK},
+		{name: "gzip in capitals", encoding: "GZIP", body: full, wantStatus: http.StatusOK},
+		{name: "identity", encoding: "identity", body: []byte(event), wantStatus: http.StatusOK},
+		{name: "decoded size over the limit", encoding: "gzip", body: gzipped(large), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "not gzip", encoding: "gzip", body: []byte(event), wantStatus: http.StatusBadRequest},
+		{name: "truncated gzip", encoding: "gzip", body: full[:len(full)-10], wantStatus: http.StatusBadRequest},
+		{name: "unsupported encoding", encoding: "br", body: []byte(event), wantStatus: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.MaxPayloadBytes = 4 << 10
+			if len(tt.body) > int(cfg.MaxPayloadBytes) {
+				t.Fatalf("compressed body of %d bytes is itself over the limit", len(tt.body))
+			}
+			s, _, _ := newTestServer(t, cfg)
+			ch := addClients(s, 1, defaultChannel, 1)[0]
+
+			r := authRequest(t, http.MethodPost, "/trigger", 1, bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", tt.encoding)
+			w := serve(s, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if len(ch) != 0 {
+					t.Error("rejected trigger was broadcast")
+				}
+				return
+			}
+			if f := <-ch; !bytes.Contains(f.Data, []byte(`"text":"hi"`)) {
+				t.Errorf("event data = %s", f.Data)
+			}
+		})
+	}
+}
+
+// TestTriggerEventTypeInjection sends an event_type that, written as is,
+// would end the frame and forge logout and close frames.
+func TestTriggerEventTypeInjection(t *testing.T) {
+	tests := []struct {
+		name       string
+		eventType  string
+		wantStatus int
+	}{
+		{"forged frames", "x\ndata: {\"event\":\"logout\"}\n\nevent: close", http.StatusUnprocessableEntity},
+		{"carriage return", "x\revent: close", http.StatusUnprocessableEntity},
+		{"space", "order shipped", http.StatusUnprocessableEntity},
+		{"token characters", "orders.v2:shipped_at-1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestServer(t, testConfig())
+			ch := addClients(s, 1, defaultChannel, 1)[0]
+
+			body, _ := json.Marshal(map[string]any{"event_type": tt.eventType, "payload": map[string]string{}})
+			w := serve(s, authRequest(t, http.MethodPost, "/trigger", 1, bytes.NewReader(body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(w.Body.String(), `"invalid_event_type"`) {
+					t.Errorf("body %s does not name invalid_event_type", w.Body)
+				}
+				if len(ch) != 0 {
+					t.Error("rejected trigger was broadcast")
+				}
+				return
+			}
+			if f := <-ch; f.Event != tt.eventType {
+				t.Errorf("event = %q, want %q", f.Event, tt.eventType)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outcomes of TriggerService.Trigger, reported in TriggerResult.Status.
+const (
+	triggerStatusTriggered    = "triggered"
+	triggerStatusScheduled    = "scheduled"
+	triggerStatusQueued       = "queued"
+	triggerStatusThrottled    = "throttled"
+	triggerStatusDeduplicated = "deduplicated"
+)
+
+// errInvalidPayload is returned when a channel has a schema and the
+// payload is not JSON it can be checked against.
+var errInvalidPayload = errors.New("invalid payload")
+
+// TriggerError is a request the caller can fix. Code becomes the "error"
+// field of the 422 response and Details the rest of it.
+type TriggerError struct {
+	Code    string
+	Details map[string]any
+}
+
+func (e *TriggerError) Error() string { return e.Code }
+
+// TriggerResult describes what Trigger did with an event.
+type TriggerResult struct {
+	Status  string
+	EventID string
+	// OriginalEventID is the event a deduplicated trigger matched.
+	OriginalEventID string
+	// DeliverAt is set for scheduled events.
+	DeliverAt time.Time
+	// QueuePosition is set for throttled events.
+	QueuePosition int64
+	// Delivered and Dropped count local clients for triggered events.
+	Delivered int
+	Dropped   int
+}
+
+// TriggerService validates, stores and broadcasts events. It is what
+// POST /trigger and /channels/{name}/publish do once the body has been
+// decoded, and can be called directly by code that has a TriggerRequest
+// in hand.
+type TriggerService struct {
+	srv *Server
+}
+
+// Trigger handles one event. A *TriggerError or errInvalidPayload means
+// the request was rejected. Under BackpressureError a broadcast that
+// missed clients returns the result together with a *DroppedClientsError.
+// Any other error is internal.
+func (t *TriggerService) Trigger(ctx context.Context, req TriggerRequest) (TriggerResult, error) {
+	s := t.srv
+
+	if err := t.validate(ctx, req); err != nil {
+		return TriggerResult{}, err
+	}
+
+	eventID := uuid.NewString()
+
+	// The claim is made before the event is accepted so that concurrent
+	// duplicates cannot both get through. If the event is then not stored
+	// or queued after all, it is released, so retries are not answered
+	// with an original_event_id that does not exist.
+	release := func() {}
+	if s.config.DedupWindow > 0 {
+		hash := dedupHash(req)
+		if original, dup := s.dedup.claim(hash, eventID, s.clock.Now(), s.config.DedupWindow); dup {
+			return TriggerResult{Status: triggerStatusDeduplicated, OriginalEventID: original}, nil
+		}
+		release = func() { s.dedup.release(hash, eventID) }
+	}
+	fail := func(err error) (TriggerResult, error) {
+		release()
+		return TriggerResult{}, err
+	}
+
+	msg, stored, err := t.envelope(eventID, req)
+	if err != nil {
+		return fail(err)
+	}
+	res := TriggerResult{EventID: eventID}
+
+	switch {
+	case req.DeliverAt != nil:
+		if err := s.enqueueEvent(ctx, eventID, req.Channel, req.EventType, msg, stored, *req.DeliverAt); err != nil {
+			return fail(fmt.Errorf("schedule event: %w", err))
+		}
+		res.Status, res.DeliverAt = triggerStatusScheduled, *req.DeliverAt
+		return res, nil
+
+	case req.Async:
+		if err := s.enqueueEvent(ctx, eventID, req.Channel, req.EventType, msg, stored, time.Time{}); err != nil {
+			return fail(fmt.Errorf("queue event: %w", err))
+		}
+		res.Status = triggerStatusQueued
+		return res, nil
+
+	case s.channelBacklogged(req.Channel) || !s.allowChannel(req.Channel):
+		position, err := s.throttleEvent(ctx, eventID, req.Channel, req.EventType, msg, stored)
+		if err != nil {
+			return fail(fmt.Errorf("queue throttled event: %w", err))
+		}
+		res.Status, res.QueuePosition = triggerStatusThrottled, position
+		return res, nil
+	}
+
+	if err := s.saveEvent(ctx, eventID, req.Channel, req.EventType, stored); err != nil {
+		s.logger.Error("Failed to save event", "event_id", eventID, "error", err)
+		release()
+	}
+	res.Delivered, res.Dropped, err = s.broadcastToChannel(ctx, req.Channel, eventFrame(eventID, req.EventType, msg))
+	s.markDelivered(ctx, eventID)
+	res.Status = triggerStatusTriggered
+	return res, err
+}
+
+// validate checks the channel name, schedule, event type and channel
+// schema.
+func (t *TriggerService) validate(ctx context.Context, req TriggerRequest) error {
+	s := t.srv
+
+	if verr := s.validateChannelName(req.Channel); verr != nil {
+		return &TriggerError{Code: "invalid_channel_name", Details: map[string]any{
+			"reason": verr.Reason,
+			"detail": verr.Detail,
+		}}
+	}
+
+	if req.DeliverAt != nil {
+		now := s.clock.Now()
+		switch {
+		case req.DeliverAt.Before(now):
+			return &TriggerError{Code: "schedule_in_past"}
+		case req.DeliverAt.After(now.Add(s.config.ScheduleMaxAdvance)):
+			return &TriggerError{Code: "schedule_too_far", Details: map[string]any{
+				"max_advance": formatDuration(s.config.ScheduleMaxAdvance),
+			}}
+		}
+	}
+
+	if !validEventType(req.EventType) {
+		return &TriggerError{Code: "invalid_event_type", Details: map[string]any{
+			"pattern": eventTypePattern.String(),
+		}}
+	}
+	if !s.eventTypes.Allowed(req.EventType) {
+		return &TriggerError{Code: "unknown_event_type", Details: map[string]any{
+			"allowed": s.eventTypes.List(),
+		}}
+	}
+
+	schema, err := s.channelSchema(ctx, req.Channel)
+	if err != nil {
+		return fmt.Errorf("load schema of channel %s: %w", req.Channel, err)
+	}
+	if schema != nil {
+		failures, err := validatePayload(schema, req.Payload)
+		if err != nil {
+			return errInvalidPayload
+		}
+		if failures != nil {
+			return &TriggerError{Code: "schema_validation_failed", Details: map[string]any{
+				"errors": failures,
+			}}
+		}
+	}
+	return nil
+}
+
+// envelope builds the event subscribers receive and the one stored in
+// history. They differ only when STRIP_FIELDS removed something:
+// subscribers never see stripped fields, and the stored event shows that
+// they were there.
+func (t *TriggerService) envelope(eventID string, req TriggerRequest) (msg, stored []byte, err error) {
+	s := t.srv
+
+	omitted, marked, stripped := stripFields(req.Payload, s.config.StripFields)
+	if stripped != nil {
+		s.logger.Warn("Stripped sensitive fields from payload", "event_id", eventID, "fields", stripped)
+		req.Payload = omitted
+	}
+
+	var payload map[string]any
+	if req.Payload == nil && req.EventType == legacyEventType {
+		payload = map[string]any{"event_id": eventID, "number": 1, "timestamp": s.clock.Now().Unix()}
+	} else {
+		payload = map[string]any{
+			"event_id":   eventID,
+			"channel":    req.Channel,
+			"event_type": req.EventType,
+			"timestamp":  s.clock.Now().Unix(),
+		}
+		if req.Payload != nil {
+			payload["payload"] = req.Payload
+		}
+		if req.Message != "" {
+			payload["message"] = req.Message
+		}
+	}
+	if req.TraceContext != nil {
+		payload["trace_context"] = req.TraceContext
+	}
+
+	if msg, err = jsonMarshal(payload); err != nil {
+		return nil, nil, err
+	}
+	stored = msg
+	if stripped != nil {
+		payload["payload"] = marked
+		if stored, err = jsonMarshal(payload); err != nil {
+			return nil, nil, err
+		}
+	}
+	return msg, stored, nil
+}
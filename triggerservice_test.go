@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTriggerService(t *testing.T) {
+	payload := json.RawMessage(`{"text":"hi"}`)
+	event := TriggerRequest{Channel: "room", EventType: "notification", Payload: payload}
+	with := func(f func(r *TriggerRequest)) TriggerRequest {
+		r := event
+		f(&r)
+		return r
+	}
+	future := newFakeClock().Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		cfg  func(c *Config)
+		// before is triggered first, for outcomes that depend on an
+		// earlier event.
+		before *TriggerRequest
+		req    TriggerRequest
+		// slow gives the subscriber a full buffer.
+		slow          bool
+		wantStatus    string
+		wantCode      string
+		wantDropped   bool
+		wantDelivered int
+	}{
+		{name: "triggered", req: event, wantStatus: triggerStatusTriggered, wantDelivered: 1},
+		{name: "scheduled", req: with(func(r *TriggerRequest) { r.DeliverAt = &future }), wantStatus: triggerStatusScheduled},
+		{name: "queued", req: with(func(r *TriggerRequest) { r.Async = true }), wantStatus: triggerStatusQueued},
+		{name: "throttled", cfg: func(c *Config) { c.ChannelRateLimit, c.ChannelBurst = 1, 1 },
+			before: &event, req: event, wantStatus: triggerStatusThrottled},
+		{name: "deduplicated", cfg: func(c *Config) { c.DedupWindow = time.Second },
+			before: &event, req: event, wantStatus: triggerStatusDeduplicated},
+		{name: "invalid channel", req: with(func(r *TriggerRequest) { r.Channel = "bad name!" }), wantCode: "invalid_channel_name"},
+		{name: "event type with a line break", req: with(func(r *TriggerRequest) { r.EventType = "x\ndata: forged" }), wantCode: "invalid_event_type"},
+		{name: "event type too long", req: with(func(r *TriggerRequest) { r.EventType = strings.Repeat("x", 65) }), wantCode: "invalid_event_type"},
+		{name: "empty event type", req: with(func(r *TriggerRequest) { r.EventType = "" }), wantCode: "invalid_event_type"},
+		{name: "schedule in the past", req: with(func(r *TriggerRequest) { past := future.Add(-2 * time.Hour); r.DeliverAt = &past }), wantCode: "schedule_in_past"},
+		{name: "clients dropped", cfg: func(c *Config) { c.BackpressureStrategy = BackpressureError },
+			slow: true, req: event, wantStatus: triggerStatusTriggered, wantDropped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			s, fake, _ := newTestServer(t, cfg)
+			fake.setHandler((&pendingTable{}).handle)
+			ch := addClients(s, 1, "room", 1)[0]
+
+			ctx := context.Background()
+			var first TriggerResult
+			if tt.before != nil {
+				var err error
+				if first, err = s.Triggers().Trigger(ctx, *tt.before); err != nil {
+					t.Fatal(err)
+				}
+				<-ch
+			}
+			if tt.slow {
+				ch <- eventFrame("stale", legacyEventType, []byte(`{}`))
+			}
+
+			res, err := s.Triggers().Trigger(ctx, tt.req)
+
+			var (
+				triggerErr *TriggerError
+				dropErr    *DroppedClientsError
+			)
+			switch {
+			case tt.wantCode != "":
+				if !errors.As(err, &triggerErr) || triggerErr.Code != tt.wantCode {
+					t.Fatalf("err = %v, want TriggerError %s", err, tt.wantCode)
+				}
+				return
+			case tt.wantDropped:
+				if !errors.As(err, &dropErr) || len(dropErr.UserIDs) != 1 {
+					t.Fatalf("err = %v, want DroppedClientsError for one user", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if res.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", res.Status, tt.wantStatus)
+			}
+			if res.Delivered != tt.wantDelivered {
+				t.Errorf("Delivered = %d, want %d", res.Delivered, tt.wantDelivered)
+			}
+			switch res.Status {
+			case triggerStatusDeduplicated:
+				if res.OriginalEventID != first.EventID {
+					t.Errorf("OriginalEventID = %q, want %q", res.OriginalEventID, first.EventID)
+				}
+			case triggerStatusScheduled:
+				if !res.DeliverAt.Equal(future) {
+					t.Errorf("DeliverAt = %v, want %v", res.DeliverAt, future)
+				}
+			case triggerStatusThrottled:
+				if res.QueuePosition != 1 {
+					t.Errorf("QueuePosition = %d, want 1", res.QueuePosition)
+				}
+			}
+			if res.Status != triggerStatusDeduplicated && res.EventID == "" {
+				t.Error("no EventID")
+			}
+			if want := tt.wantDelivered; len(ch) != want && !tt.slow {
+				t.Errorf("subscriber has %d events queued, want %d", len(ch), want)
+			}
+		})
+	}
+}
+
+// failingStore is a MessageStore whose Save always fails.
+type failingStore struct{ MessageStore }
+
+func (failingStore) Save(context.Context, Event) error { return errors.New("disk full") }
+
+// TestTriggerDedupReleasedOnFailure checks that a trigger that fails
+// after claiming its dedup entry does not make a retry look like a
+// duplicate of an event that was never stored or queued.
+func TestTriggerDedupReleasedOnFailure(t *testing.T) {
+	event := TriggerRequest{Channel: "room", EventType: "notification", Payload: json.RawMessage(`{"text":"hi"}`)}
+	future := newFakeClock().Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		cfg  func(c *Config)
+		req  func(r *TriggerRequest)
+		// failSave makes history fail instead of pending_events, and the
+		// trigger still broadcasts.
+		failSave   bool
+		wantStatus string
+	}{
+		{name: "scheduled", req: func(r *TriggerRequest) { r.DeliverAt = &future }, wantStatus: triggerStatusScheduled},
+		{name: "queued", req: func(r *TriggerRequest) { r.Async = true }, wantStatus: triggerStatusQueued},
+		{name: "throttled", cfg: func(c *Config) { c.ChannelRateLimit, c.ChannelBurst = 1, 1 }, wantStatus: triggerStatusThrottled},
+		{name: "not saved", failSave: true, wantStatus: triggerStatusTriggered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.DedupWindow = time.Minute
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			var opts []Option
+			if tt.failSave {
+				opts = append(opts, WithMessageStore(failingStore{NewMemoryMessageStore()}))
+			}
+			s, fake, _ := newTestServer(t, cfg, opts...)
+			table := &pendingTable{}
+			fake.setHandler(table.handle)
+			addClients(s, 1, "room", 10)
+			req := event
+			if tt.req != nil {
+				tt.req(&req)
+			}
+			ctx := context.Background()
+
+			if tt.wantStatus == triggerStatusThrottled {
+				// Use up the burst with another event.
+				other := event
+				other.Payload = json.RawMessage(`{"text":"first"}`)
+				if _, err := s.Triggers().Trigger(ctx, other); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !tt.failSave {
+				fake.setHandler(func(q fakeQuery) fakeResult {
+					if strings.Contains(q.SQL, "pending_events") {
+						return fakeResult{Err: errors.New("connection reset")}
+					}
+					return table.handle(q)
+				})
+			}
+			failed, err := s.Triggers().Trigger(ctx, req)
+			if tt.failSave && err != nil || !tt.failSave && err == nil {
+				t.Fatalf("failing trigger: %+v, %v", failed, err)
+			}
+
+			fake.setHandler(table.handle)
+			retry, err := s.Triggers().Trigger(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if retry.Status != tt.wantStatus {
+				t.Fatalf("retry Status = %q (original %q), want %q", retry.Status, retry.OriginalEventID, tt.wantStatus)
+			}
+			if tt.failSave {
+				return
+			}
+			// Once accepted, the event is remembered as usual.
+			dup, err := s.Triggers().Trigger(ctx, req)
+			if err != nil || dup.Status != triggerStatusDeduplicated || dup.OriginalEventID != retry.EventID {
+				t.Errorf("second retry = %+v, %v; want deduplicated against %s", dup, err, retry.EventID)
+			}
+		})
+	}
+}
+
+func TestDedupRingRelease(t *testing.T) {
+	var d dedupRing
+	now := newFakeClock().Now()
+	d.claim(1, "a", now, time.Minute)
+	d.claim(2, "b", now, time.Minute)
+
+	d.release(1, "other")
+	if original, dup := d.claim(1, "c", now, time.Minute); !dup || original != "a" {
+		t.Fatalf("release of another ID dropped the entry: %q, %v", original, dup)
+	}
+	d.release(1, "a")
+	if _, dup := d.claim(1, "c", now, time.Minute); dup {
+		t.Error("released entry still matches")
+	}
+	if original, dup := d.claim(2, "d", now, time.Minute); !dup || original != "b" {
+		t.Errorf("unrelated entry lost: %q, %v", original, dup)
+	}
+}
+
+// TestStrippedFieldsInHistory checks that history marks stripped fields
+// whether an event is broadcast at once or goes through pending_events,
+// while subscribers never see them.
+func TestStrippedFieldsInHistory(t *testing.T) {
+	event := TriggerRequest{Channel: "room", EventType: "notification",
+		Payload: json.RawMessage(`{"text":"hi","password":"hunter2"}`)}
+	future := newFakeClock().Now().Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		cfg        func(c *Config)
+		req        func(r *TriggerRequest)
+		wantStatus string
+	}{
+		{name: "triggered", wantStatus: triggerStatusTriggered},
+		{name: "scheduled", req: func(r *TriggerRequest) { r.DeliverAt = &future }, wantStatus: triggerStatusScheduled},
+		{name: "queued", req: func(r *TriggerRequest) { r.Async = true }, wantStatus: triggerStatusQueued},
+		{name: "throttled", cfg: func(c *Config) { c.ChannelRateLimit, c.ChannelBurst = 1, 1 }, wantStatus: triggerStatusThrottled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.StripFields = []string{"password"}
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			s, fake, clock := newTestServer(t, cfg)
+			fake.setHandler((&pendingTable{}).handle)
+			ch := addClients(s, 1, "room", 1)[0]
+			req := event
+			if tt.req != nil {
+				tt.req(&req)
+			}
+
+			ctx := context.Background()
+			if tt.wantStatus == triggerStatusThrottled {
+				// Use up the burst with another event.
+				if _, err := s.Triggers().Trigger(ctx, TriggerRequest{Channel: "room", EventType: "notification"}); err != nil {
+					t.Fatal(err)
+				}
+				<-ch
+			}
+			res, err := s.Triggers().Trigger(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != tt.wantStatus {
+				t.Fatalf("Status = %q, want %q", res.Status, tt.wantStatus)
+			}
+			if res.Status != triggerStatusTriggered {
+				clock.Advance(time.Second)
+				waitFor(t, time.Second, func() bool {
+					if err := s.processPendingBatch(ctx); err != nil {
+						t.Fatal(err)
+					}
+					return len(ch) == 1
+				})
+			}
+
+			if f := <-ch; strings.Contains(string(f.Data), "password") {
+				t.Errorf("subscriber got %s", f.Data)
+			}
+			stored, err := s.store.Get(ctx, res.EventID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg := string(stored.Message); !strings.Contains(msg, `"password":"[STRIPPED]"`) || strings.Contains(msg, "hunter2") {
+				t.Errorf("history holds %s, want password marked as stripped", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+//go:build testing
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const waitForClientsTick = 10 * time.Millisecond
+
+// WaitForClients blocks until at least n SSE clients are registered or
+// timeout elapses, so integration tests need not sleep for a guessed
+// duration. The deadline is measured against s.clock; tests using a
+// frozen clock must advance it for the wait to time out.
+func (s *Server) WaitForClients(n int, timeout time.Duration) error {
+	deadline := s.clock.Now().Add(timeout)
+	ticker := time.NewTicker(waitForClientsTick)
+	defer ticker.Stop()
+
+	for {
+		got := s.TotalClients()
+		if got >= n {
+			return nil
+		}
+		if !s.clock.Now().Before(deadline) {
+			return fmt.Errorf("%d of %d clients connected after %s", got, n, timeout)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,55 @@
+//go:build testing
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitForClients(t *testing.T) {
+	tests := []struct {
+		name string
+		// before clients are registered ahead of the wait, later ones
+		// while it runs.
+		before, later int
+		want          int
+		// advance is how far the clock is moved while waiting.
+		advance time.Duration
+		wantErr bool
+	}{
+		{name: "already connected", before: 2, want: 2},
+		{name: "more than needed", before: 3, want: 2},
+		{name: "connected while waiting", before: 1, later: 1, want: 2},
+		{name: "timeout", before: 1, want: 2, advance: time.Second, wantErr: true},
+		{name: "none wanted", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, clock := newTestServer(t, testConfig())
+			addClients(s, tt.before, "c", 1)
+			go func() {
+				time.Sleep(3 * waitForClientsTick)
+				addClients(s, tt.later, "c", 1)
+				clock.Advance(tt.advance)
+			}()
+
+			err := s.WaitForClients(tt.want, 500*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WaitForClients = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWaitForClientsOverHTTP(t *testing.T) {
+	s, _, _ := newTestServer(t, testConfig())
+	ts := startServer(t, s)
+
+	for range 3 {
+		openStream(t, ts, "/events", signToken(t, &Claims{UserID: 1}))
+	}
+	if err := s.WaitForClients(3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	pendingBatchSize    = 100
+	pendingPollInterval = time.Second
+)
+
+// enqueueEvent queues an event for the pending worker. msg is what
+// subscribers receive and stored what history keeps; see
+// TriggerService.envelope. A non-zero deliverAt holds it back until then.
+func (s *Server) enqueueEvent(ctx context.Context, eventID, channel, eventType string, msg, stored []byte, deliverAt time.Time) error {
+	var at any
+	if !deliverAt.IsZero() {
+		at = deliverAt
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO pending_events (event_id, channel, event_type, message, stored_message, deliver_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		eventID, channel, eventType, msg, storedMessage(msg, stored), at)
+	return err
+}
+
+// storedMessage is the stored_message column for an event: NULL when
+// history keeps the event as broadcast, which is unless STRIP_FIELDS
+// changed it.
+func storedMessage(msg, stored []byte) any {
+	if bytes.Equal(msg, stored) {
+		return nil
+	}
+	return stored
+}
+
+// runPendingWorker drains pending_events every second until the server
+// shuts down.
+// Several instances can run it at once: SKIP LOCKED hands each row to a
+// single worker.
+func (s *Server) runPendingWorker() {
+	ticker := time.NewTicker(pendingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.processPendingBatch(s.ctx); err != nil {
+				s.logger.Error("Failed to process pending events", "error", err)
+			}
+			if err := s.clearBacklogs(s.ctx); err != nil {
+				s.logger.Error("Failed to check throttled backlogs", "error", err)
+			}
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+type pendingEvent struct {
+	id        int64
+	status    string
+	eventID   string
+	channel   string
+	eventType string
+	message   []byte
+	// stored is the event as history keeps it, or nil when that is
+	// message.
+	stored []byte
+}
+
+func (s *Server) processPendingBatch(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, status, event_id, channel, event_type, message, stored_message FROM pending_events
+		WHERE (status IN ('pending', 'throttled') OR (status = 'error' AND attempts <= $1))
+			AND (deliver_at IS NULL OR deliver_at <= NOW())
+		ORDER BY id
+		FOR UPDATE SKIP LOCKED
+		LIMIT $2`, s.config.AsyncMaxRetries, pendingBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var batch []pendingEvent
+	for rows.Next() {
+		var e pendingEvent
+		if err := rows.Scan(&e.id, &e.status, &e.eventID, &e.channel, &e.eventType, &e.message, &e.stored); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// held records channels with a throttled row left for a later batch;
+	// their later rows wait too, so they keep their order.
+	held := make(map[string]bool)
+	for _, e := range batch {
+		// Throttled rows wait for their channel's bucket to refill and are
+		// left for a later batch otherwise.
+		if e.status == "throttled" && (held[e.channel] || !s.allowChannel(e.channel)) {
+			held[e.channel] = true
+			continue
+		}
+		if err := s.deliverPending(ctx, e); err != nil {
+			s.logger.Warn("Pending event failed", "id", e.id, "error", err)
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE pending_events
+				SET status = 'error', attempts = attempts + 1, last_error = $2, updated_at = NOW()
+				WHERE id = $1`, e.id, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := markPending(ctx, tx, e.id, "done"); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Server) deliverPending(ctx context.Context, e pendingEvent) error {
+	if len(e.message) == 0 {
+		return fmt.Errorf("empty message")
+	}
+	history := e.message
+	if e.stored != nil {
+		history = e.stored
+	}
+	if err := s.saveEvent(ctx, e.eventID, e.channel, e.eventType, history); err != nil {
+		s.logger.Error("Failed to save event", "event_id", e.eventID, "error", err)
+	}
+	// Retrying after a partial delivery would duplicate the event for
+	// every client that did get it, so backpressure errors are only logged.
+	if _, _, err := s.broadcastToChannel(ctx, e.channel, eventFrame(e.eventID, e.eventType, e.message)); err != nil {
+		s.logger.Warn("Pending event partially delivered", "event_id", e.eventID, "error", err)
+	}
+	s.markDelivered(ctx, e.eventID)
+	return nil
+}
+
+func markPending(ctx context.Context, tx *sql.Tx, id int64, status string) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE pending_events SET status = $2, updated_at = NOW() WHERE id = $1", id, status)
+	return err
+}